@@ -4,6 +4,7 @@ import (
 	"consent-to-fhir/pkg/config"
 	"consent-to-fhir/pkg/kafka"
 	log "github.com/sirupsen/logrus"
+	"net/http"
 	"os"
 )
 
@@ -14,6 +15,7 @@ func main() {
 		os.Exit(1)
 	}
 	configureLogger(appConfig.App)
+	serveMetrics(appConfig.App.MetricsAddress)
 
 	p := kafka.NewProcessor(*appConfig)
 	p.Run()
@@ -27,3 +29,17 @@ func configureLogger(config config.App) {
 	}
 	log.SetLevel(level)
 }
+
+// serveMetrics exposes expvar metrics at /debug/vars, if configured
+func serveMetrics(address string) {
+	if address == "" {
+		return
+	}
+
+	go func() {
+		err := http.ListenAndServe(address, nil)
+		if err != nil {
+			log.WithError(err).Error("Failed to serve metrics")
+		}
+	}()
+}
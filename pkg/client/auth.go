@@ -0,0 +1,166 @@
+package client
+
+import (
+	"consent-to-fhir/pkg/config"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// refreshMargin is the time before expiry, at which access tokens are refreshed
+const refreshMargin = 30 * time.Second
+
+// Authenticator sets the authorization of requests
+type Authenticator interface {
+	Authenticate(ctx context.Context, req *http.Request) error
+}
+
+// invalidator is implemented by authenticators with cached credentials
+type invalidator interface {
+	Invalidate()
+}
+
+// NewAuthenticator creates an OAuth2 client credentials authenticator, if a token url is configured, or
+// basic auth, if a user is configured. Returns nil otherwise. Tokens are requested with a client for the
+// token endpoint's own TLS configuration
+func NewAuthenticator(auth *config.Auth) (Authenticator, error) {
+	switch {
+	case auth == nil:
+		return nil, nil
+	case auth.OAuth.TokenUrl != "":
+		httpClient, err := NewHttpClient(auth.OAuth.Tls)
+		if err != nil {
+			return nil, err
+		}
+		ts := NewTokenSource(auth.OAuth)
+		ts.HttpClient = httpClient
+		return ts, nil
+	case auth.User != "":
+		return &BasicAuth{User: auth.User, Password: auth.Password}, nil
+	default:
+		return nil, nil
+	}
+}
+
+// BasicAuth sets the basic authorization header
+type BasicAuth struct {
+	User     string
+	Password string
+}
+
+func (a *BasicAuth) Authenticate(_ context.Context, req *http.Request) error {
+	req.SetBasicAuth(a.User, a.Password)
+	return nil
+}
+
+// TokenSource requests access tokens with the OAuth2 client credentials grant. Tokens are cached and
+// refreshed shortly before they expire
+type TokenSource struct {
+	Config     config.OAuth
+	HttpClient *http.Client
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+	now    func() time.Time
+}
+
+func NewTokenSource(c config.OAuth) *TokenSource {
+	return &TokenSource{Config: c, now: time.Now}
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// Authenticate sets the bearer authorization header with a valid access token
+func (s *TokenSource) Authenticate(ctx context.Context, req *http.Request) error {
+	token, err := s.Token(ctx)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// Token returns the cached access token or requests a new one, if it expires soon
+func (s *TokenSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != "" && (s.expiry.IsZero() || s.now().Add(refreshMargin).Before(s.expiry)) {
+		return s.token, nil
+	}
+
+	res, err := s.requestToken(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	s.token = res.AccessToken
+	s.expiry = time.Time{}
+	if res.ExpiresIn > 0 {
+		s.expiry = s.now().Add(time.Duration(res.ExpiresIn) * time.Second)
+	}
+	return s.token, nil
+}
+
+// Invalidate discards the cached access token, e.g. after it has been rejected
+func (s *TokenSource) Invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token = ""
+}
+
+func (s *TokenSource) requestToken(ctx context.Context) (*tokenResponse, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if s.Config.Scope != "" {
+		form.Set("scope", s.Config.Scope)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.Config.TokenUrl, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(s.Config.ClientId), url.QueryEscape(s.Config.ClientSecret))
+
+	response, err := httpClientOrDefault(s.HttpClient).Do(req)
+	if err != nil {
+		return nil, &RequestError{Class: ErrTransient, Method: req.Method, Url: req.URL.String(), Err: err}
+	}
+	defer closeBody(response.Body)
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, &RequestError{Class: ErrTransient, Method: req.Method, Url: req.URL.String(),
+			StatusCode: response.StatusCode, Err: err}
+	}
+	if code := response.StatusCode; code != http.StatusOK {
+		if code == http.StatusBadRequest {
+			// invalid_client, unauthorized_client etc.
+			code = http.StatusUnauthorized
+		}
+		return nil, responseError(req, code, body)
+	}
+
+	var res tokenResponse
+	if err = json.Unmarshal(body, &res); err != nil || res.AccessToken == "" {
+		if err == nil {
+			err = errors.New("missing access token")
+		}
+		return nil, &RequestError{Class: ErrMalformed, Method: req.Method, Url: req.URL.String(),
+			StatusCode: response.StatusCode, Err: err}
+	}
+	return &res, nil
+}
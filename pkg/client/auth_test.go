@@ -0,0 +1,220 @@
+package client
+
+import (
+	"consent-to-fhir/pkg/config"
+	"consent-to-fhir/pkg/model"
+	"context"
+	"encoding/pem"
+	"fmt"
+	"github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNewAuthenticator(t *testing.T) {
+
+	cases := []struct {
+		name     string
+		auth     *config.Auth
+		expected Authenticator
+	}{
+		{"none", nil, nil},
+		{"empty", &config.Auth{}, nil},
+		{"basic", &config.Auth{User: "user", Password: "secret"}, &BasicAuth{User: "user", Password: "secret"}},
+		{"oauth", &config.Auth{User: "user", OAuth: config.OAuth{TokenUrl: "http://token"}},
+			NewTokenSource(config.OAuth{TokenUrl: "http://token"})},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			actual, err := NewAuthenticator(c.auth)
+
+			assert.NoError(t, err)
+			if ts, ok := actual.(*TokenSource); ok {
+				assert.Equal(t, c.expected.(*TokenSource).Config, ts.Config)
+			} else {
+				assert.Equal(t, c.expected, actual)
+			}
+		})
+	}
+}
+
+func TestNewAuthenticator_Tls(t *testing.T) {
+
+	cases := []struct {
+		name            string
+		tls             config.Tls
+		expectedDefault bool
+		wantErr         bool
+	}{
+		{"default", config.Tls{}, true, false},
+		{"dedicated", config.Tls{MinVersion: "1.2"}, false, false},
+		{"invalid", config.Tls{MinVersion: "1.4"}, false, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			actual, err := NewAuthenticator(&config.Auth{OAuth: config.OAuth{TokenUrl: "https://token", Tls: c.tls}})
+
+			if c.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, c.expectedDefault, actual.(*TokenSource).HttpClient == http.DefaultClient)
+		})
+	}
+}
+
+func TestTokenSource_Token(t *testing.T) {
+
+	var requests atomic.Int32
+	s := withTokenServer(&requests, 300)
+	defer s.Close()
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ts := NewTokenSource(config.OAuth{TokenUrl: s.URL, ClientId: "client", ClientSecret: "secret", Scope: "gics"})
+	ts.now = func() time.Time { return now }
+
+	// cached
+	first, err := ts.Token(context.Background())
+	assert.NoError(t, err)
+	second, _ := ts.Token(context.Background())
+	assert.Equal(t, "token-1", first)
+	assert.Equal(t, first, second)
+
+	// refreshed before expiry
+	now = now.Add(300*time.Second - refreshMargin)
+	third, _ := ts.Token(context.Background())
+	assert.Equal(t, "token-2", third)
+
+	// invalidated
+	ts.Invalidate()
+	fourth, _ := ts.Token(context.Background())
+	assert.Equal(t, "token-3", fourth)
+	assert.Equal(t, int32(3), requests.Load())
+}
+
+func TestTokenSource_Errors(t *testing.T) {
+
+	cases := []struct {
+		name     string
+		code     int
+		body     string
+		expected error
+	}{
+		{"invalidClient", http.StatusBadRequest, `{"error":"invalid_client"}`, ErrUnauthorized},
+		{"unavailable", http.StatusServiceUnavailable, "", ErrTransient},
+		{"missingToken", http.StatusOK, `{"token_type":"Bearer"}`, ErrMalformed},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := withTestServer([]byte(c.body), c.code)
+			defer s.Close()
+
+			_, err := NewTokenSource(config.OAuth{TokenUrl: s.URL}).Token(context.Background())
+
+			assert.ErrorIs(t, err, c.expected)
+		})
+	}
+}
+
+func TestGetConsentStatus_OAuth(t *testing.T) {
+
+	var tokens atomic.Int32
+	ts := withTokenServer(&tokens, 300)
+	defer ts.Close()
+
+	b, _ := fhir.Bundle{}.MarshalJSON()
+	var authorization []string
+	s := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		authorization = append(authorization, req.Header.Get("Authorization"))
+		if len(authorization) == 2 {
+			// token revoked
+			res.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = res.Write(b)
+	}))
+	defer s.Close()
+
+	c := NewGicsClient(config.AppConfig{Gics: config.Gics{
+		Fhir: config.Fhir{Base: s.URL, Auth: &config.Auth{OAuth: config.OAuth{TokenUrl: ts.URL, ClientId: "client"}}},
+	}})
+
+	for range 3 {
+		_, _ = c.GetConsentStatus(context.Background(), model.SignerId{Id: "test"}, "domain", "2024-01-01")
+	}
+
+	assert.Equal(t, []string{"Bearer token-1", "Bearer token-1", "Bearer token-2"}, authorization)
+}
+
+func TestGetConsentDomain_OAuthTls(t *testing.T) {
+
+	var tokens atomic.Int32
+	ts := httptest.NewTLSServer(tokenHandler(&tokens, 300))
+	defer ts.Close()
+
+	id := "test-id"
+	study, _ := fhir.ResearchStudy{Id: &id}.MarshalJSON()
+	s := httptest.NewTLSServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		_, _ = res.Write(study)
+	}))
+	defer s.Close()
+
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	_ = os.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.Certificate().Raw}), 0o600)
+
+	cases := []struct {
+		name    string
+		tls     config.Tls
+		wantErr bool
+	}{
+		// the gICS CA does not apply to the token endpoint
+		{"gicsTlsOnly", config.Tls{}, true},
+		{"oauthTls", config.Tls{CaFile: ca}, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			client := NewGicsClient(config.AppConfig{Gics: config.Gics{
+				Fhir: config.Fhir{Base: s.URL, Tls: config.Tls{CaFile: ca}, Auth: &config.Auth{
+					OAuth: config.OAuth{TokenUrl: ts.URL, ClientId: "client", Tls: c.tls},
+				}},
+			}})
+
+			_, err := client.GetConsentDomain(context.Background(), "ResearchStudy/test-id")
+
+			if c.wantErr {
+				assert.ErrorIs(t, err, ErrTransient)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func withTokenServer(requests *atomic.Int32, expiresIn int) *httptest.Server {
+	return httptest.NewServer(tokenHandler(requests, expiresIn))
+}
+
+func tokenHandler(requests *atomic.Int32, expiresIn int) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		user, _, ok := req.BasicAuth()
+		if !ok || user != "client" || req.PostFormValue("grant_type") != "client_credentials" {
+			res.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		n := requests.Add(1)
+		res.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(res, `{"access_token":"token-%d","token_type":"Bearer","expires_in":%d}`, n, expiresIn)
+	}
+}
@@ -0,0 +1,98 @@
+package client
+
+import (
+	"consent-to-fhir/pkg/config"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen signals, that gICS is considered unavailable and requests are rejected until the
+// circuit breaker's open duration has passed
+var ErrCircuitOpen = errors.New("gICS circuit breaker is open")
+
+const defaultOpenDuration = 30 * time.Second
+
+// CircuitBreaker rejects requests after a number of consecutive failures. Once the open duration has
+// passed, requests are let through again and a single failure opens the circuit again (half-open)
+type CircuitBreaker struct {
+	Threshold    int
+	OpenDuration time.Duration
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	now      func() time.Time
+}
+
+// NewCircuitBreaker creates a circuit breaker from config. Returns nil, if no failure threshold is configured
+func NewCircuitBreaker(c config.CircuitBreaker) *CircuitBreaker {
+	if c.FailureThreshold <= 0 {
+		return nil
+	}
+
+	openDuration := c.OpenDuration
+	if openDuration <= 0 {
+		openDuration = defaultOpenDuration
+	}
+	return &CircuitBreaker{Threshold: c.FailureThreshold, OpenDuration: openDuration, now: time.Now}
+}
+
+// Allow returns ErrCircuitOpen, if requests are currently rejected
+func (b *CircuitBreaker) Allow() error {
+	if b == nil {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.isOpen() {
+		return ErrCircuitOpen
+	}
+	return nil
+}
+
+// Success resets the failure count and closes the circuit
+func (b *CircuitBreaker) Success() {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+}
+
+// Failure records a failed request and returns true, if the circuit has been opened by it
+func (b *CircuitBreaker) Failure() bool {
+	if b == nil {
+		return false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.failures >= b.Threshold {
+		b.openedAt = b.now()
+		return true
+	}
+	return false
+}
+
+// OpenFor returns the remaining time requests are rejected. Zero, if the circuit is closed
+func (b *CircuitBreaker) OpenFor() time.Duration {
+	if b == nil {
+		return 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.isOpen() {
+		return 0
+	}
+	return b.openedAt.Add(b.OpenDuration).Sub(b.now())
+}
+
+func (b *CircuitBreaker) isOpen() bool {
+	return b.failures >= b.Threshold && b.now().Before(b.openedAt.Add(b.OpenDuration))
+}
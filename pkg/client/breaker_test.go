@@ -0,0 +1,43 @@
+package client
+
+import (
+	"consent-to-fhir/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestNewCircuitBreaker_Disabled(t *testing.T) {
+
+	b := NewCircuitBreaker(config.CircuitBreaker{})
+
+	assert.Nil(t, b)
+	assert.NoError(t, b.Allow())
+	assert.False(t, b.Failure())
+}
+
+func TestCircuitBreaker(t *testing.T) {
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := NewCircuitBreaker(config.CircuitBreaker{FailureThreshold: 2, OpenDuration: time.Minute})
+	b.now = func() time.Time { return now }
+
+	assert.False(t, b.Failure())
+	assert.NoError(t, b.Allow())
+
+	// opens after threshold
+	assert.True(t, b.Failure())
+	assert.ErrorIs(t, b.Allow(), ErrCircuitOpen)
+	assert.Equal(t, time.Minute, b.OpenFor())
+
+	// half-open after open duration, single failure opens again
+	now = now.Add(time.Minute)
+	assert.NoError(t, b.Allow())
+	assert.True(t, b.Failure())
+	assert.ErrorIs(t, b.Allow(), ErrCircuitOpen)
+
+	// success closes
+	b.Success()
+	assert.NoError(t, b.Allow())
+	assert.Zero(t, b.OpenFor())
+}
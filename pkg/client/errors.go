@@ -0,0 +1,91 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// error classes of failed gICS and gPAS requests
+var (
+	ErrTransient    = errors.New("transient TTP-FHIR error")
+	ErrNotFound     = errors.New("TTP-FHIR resource not found")
+	ErrUnauthorized = errors.New("TTP-FHIR request unauthorized")
+	ErrRejected     = errors.New("TTP-FHIR request rejected")
+	ErrMalformed    = errors.New("malformed TTP-FHIR response")
+)
+
+// maxErrorBody is the maximum length of the response body in error messages
+const maxErrorBody = 512
+
+// RequestError is a failed gICS or gPAS request. It wraps the error class and the cause, if any, and
+// holds the response status and body
+type RequestError struct {
+	Class      error
+	Method     string
+	Url        string
+	StatusCode int
+	Body       string
+	Err        error
+}
+
+func (e *RequestError) Error() string {
+	msg := fmt.Sprintf("%s: %s request to %s failed", e.Class, e.Method, e.Url)
+	if e.StatusCode != 0 {
+		msg += fmt.Sprintf(" with status %d", e.StatusCode)
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	if body := e.Body; body != "" {
+		if len(body) > maxErrorBody {
+			body = body[:maxErrorBody] + "..."
+		}
+		msg += ": " + body
+	}
+	return msg
+}
+
+func (e *RequestError) Unwrap() []error {
+	if e.Err == nil {
+		return []error{e.Class}
+	}
+	return []error{e.Class, e.Err}
+}
+
+// classify returns the error class of a response status
+func classify(code int) error {
+	switch {
+	case code == http.StatusUnauthorized || code == http.StatusForbidden:
+		return ErrUnauthorized
+	case code == http.StatusNotFound || code == http.StatusGone:
+		return ErrNotFound
+	case isTransientStatus(code):
+		return ErrTransient
+	default:
+		return ErrRejected
+	}
+}
+
+// responseError creates the RequestError of an unsuccessful response
+func responseError(req *http.Request, code int, body []byte) *RequestError {
+	return &RequestError{
+		Class:      classify(code),
+		Method:     req.Method,
+		Url:        req.URL.String(),
+		StatusCode: code,
+		Body:       string(body),
+	}
+}
+
+// malformed creates the RequestError of a response, which cannot be deserialized
+func malformed(method, url string, body []byte, err error) *RequestError {
+	return &RequestError{
+		Class:      ErrMalformed,
+		Method:     method,
+		Url:        url,
+		StatusCode: http.StatusOK,
+		Body:       string(body),
+		Err:        err,
+	}
+}
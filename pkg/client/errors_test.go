@@ -0,0 +1,77 @@
+package client
+
+import (
+	"consent-to-fhir/pkg/config"
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestGetConsentDomain_Errors(t *testing.T) {
+
+	cases := []struct {
+		name     string
+		code     int
+		body     string
+		expected error
+	}{
+		{"notFound", http.StatusNotFound, "not found", ErrNotFound},
+		{"unauthorized", http.StatusUnauthorized, "", ErrUnauthorized},
+		{"forbidden", http.StatusForbidden, "", ErrUnauthorized},
+		{"rejected", http.StatusBadRequest, "invalid", ErrRejected},
+		{"transient", http.StatusServiceUnavailable, "unavailable", ErrTransient},
+		{"malformed", http.StatusOK, "<html>", ErrMalformed},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := withTestServer([]byte(c.body), c.code)
+			defer s.Close()
+
+			client := NewGicsClient(config.AppConfig{Gics: config.Gics{
+				Fhir: config.Fhir{Base: s.URL},
+			}})
+
+			_, err := client.GetConsentDomain(context.Background(), "ResearchStudy/1")
+
+			assert.ErrorIs(t, err, c.expected)
+			var reqErr *RequestError
+			if assert.True(t, errors.As(err, &reqErr)) {
+				assert.Equal(t, c.code, reqErr.StatusCode)
+				assert.Equal(t, c.body, reqErr.Body)
+				assert.Equal(t, s.URL+"/ResearchStudy/1", reqErr.Url)
+			}
+		})
+	}
+}
+
+func TestGetConsentDomain_NetworkError(t *testing.T) {
+
+	s := withTestServer(nil, http.StatusOK)
+	s.Close()
+
+	c := NewGicsClient(config.AppConfig{Gics: config.Gics{
+		Fhir: config.Fhir{Base: s.URL},
+	}})
+
+	_, err := c.GetConsentDomain(context.Background(), "ResearchStudy/1")
+
+	assert.ErrorIs(t, err, ErrTransient)
+}
+
+func TestRequestError_Error(t *testing.T) {
+
+	err := &RequestError{
+		Class:      ErrNotFound,
+		Method:     http.MethodGet,
+		Url:        "http://gics/ResearchStudy/1",
+		StatusCode: http.StatusNotFound,
+		Body:       strings.Repeat("x", maxErrorBody+1),
+	}
+
+	assert.Equal(t, "TTP-FHIR resource not found: GET request to http://gics/ResearchStudy/1 failed with status 404: "+
+		strings.Repeat("x", maxErrorBody)+"...", err.Error())
+}
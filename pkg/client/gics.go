@@ -4,26 +4,46 @@ import (
 	"bytes"
 	"consent-to-fhir/pkg/config"
 	"consent-to-fhir/pkg/model"
+	"context"
 	"errors"
 	"github.com/samply/golang-fhir-models/fhir-models/fhir"
 	log "github.com/sirupsen/logrus"
 	"io"
 	"net/http"
 	"strings"
+	"time"
 )
 
 type GicsClient interface {
-	GetConsentStatus(signerId model.SignerId, domain, date string) (*fhir.Bundle, error)
-	GetConsentDomain(resId string) (*fhir.ResearchStudy, error)
+	GetConsentStatus(ctx context.Context, signerId model.SignerId, domain, date string) (*fhir.Bundle, error)
+	GetConsentDomain(ctx context.Context, resId string) (*fhir.ResearchStudy, error)
+	GetQuestionnaireResponse(ctx context.Context, resId string) (*fhir.QuestionnaireResponse, error)
 	GetRequestUrl() string
 	GetAuth() *config.Auth
 }
 
 type GicsHttpClient struct {
 	Auth             *config.Auth
+	Authenticator    Authenticator
+	HttpClient       *http.Client
 	IdentifierSystem string
 	PolicyStatesUrl  string
 	BaseUrl          string
+	Timeout          time.Duration
+	Retry            config.Retry
+	Breaker          *CircuitBreaker
+}
+
+// do sends the request with the client's retry and circuit breaker settings
+func (c *GicsHttpClient) do(ctx context.Context, newRequest func(ctx context.Context) (*http.Request, error)) ([]byte, error) {
+	return requester{
+		service:       "gICS",
+		authenticator: c.Authenticator,
+		httpClient:    c.HttpClient,
+		timeout:       c.Timeout,
+		retry:         c.Retry,
+		breaker:       c.Breaker,
+	}.do(ctx, newRequest)
 }
 
 func (c *GicsHttpClient) GetRequestUrl() string {
@@ -35,59 +55,95 @@ func (c *GicsHttpClient) GetAuth() *config.Auth {
 }
 
 func NewGicsClient(config config.AppConfig) *GicsHttpClient {
+	httpClient, err := NewHttpClient(config.Gics.Fhir.Tls)
+	if err != nil {
+		log.WithError(err).Fatal("Invalid gICS TLS configuration")
+	}
+
 	client := &GicsHttpClient{
 		PolicyStatesUrl:  config.Gics.Fhir.Base + "/$currentPolicyStatesForPerson",
 		BaseUrl:          config.Gics.Fhir.Base,
 		IdentifierSystem: "https://ths-greifswald.de/fhir/gics/identifiers/",
+		Timeout:          config.Gics.Fhir.Timeout,
+		Retry:            config.Gics.Retry,
+		Breaker:          NewCircuitBreaker(config.Gics.CircuitBreaker),
+		HttpClient:       httpClient,
 	}
 	if config.Gics.Fhir.Auth != nil {
 		client.Auth = config.Gics.Fhir.Auth
+		client.Authenticator, err = NewAuthenticator(config.Gics.Fhir.Auth)
+		if err != nil {
+			log.WithError(err).Fatal("Invalid gICS OAuth TLS configuration")
+		}
 	}
 
 	return client
 }
 
-func (c *GicsHttpClient) GetConsentDomain(resId string) (*fhir.ResearchStudy, error) {
-	domainReq := c.BaseUrl + resId
-	req, err := http.NewRequest(http.MethodGet, domainReq, nil)
+func (c *GicsHttpClient) GetConsentDomain(ctx context.Context, resId string) (*fhir.ResearchStudy, error) {
+	responseData, err := c.getResource(ctx, resId)
 	if err != nil {
-		log.WithError(err).Fatal("Failed to create domain request")
 		return nil, err
 	}
-	req.Header.Set("Content-Type", "application/fhir+json")
-	if c.Auth != nil {
-		req.SetBasicAuth(c.Auth.User, c.Auth.Password)
-	}
 
-	response, err := http.DefaultClient.Do(req)
+	study, err := fhir.UnmarshalResearchStudy(responseData)
 	if err != nil {
-		log.WithError(err).Error("GET request to gICS failed for: " + domainReq)
-		return nil, err
+		log.WithError(err).Error("Failed to deserialize FHIR response from  gICS. Expected 'ResearchStudy'")
+		return nil, malformed(http.MethodGet, c.resourceUrl(resId), responseData, err)
 	}
 
-	defer closeBody(response.Body)
+	return &study, nil
+}
 
-	responseData, err := io.ReadAll(response.Body)
+func (c *GicsHttpClient) GetQuestionnaireResponse(ctx context.Context, resId string) (*fhir.QuestionnaireResponse, error) {
+	responseData, err := c.getResource(ctx, resId)
 	if err != nil {
-		log.WithError(err).Fatal("Unable to parse gICS ResearchStudy response")
-	}
-	if response.StatusCode != http.StatusOK {
-		err = errors.New("GET request to gICS failed: " + string(responseData))
-		log.WithField("statusCode", response.StatusCode).Error(err.Error())
 		return nil, err
 	}
 
-	study, err := fhir.UnmarshalResearchStudy(responseData)
+	qr, err := fhir.UnmarshalQuestionnaireResponse(responseData)
 	if err != nil {
-		log.WithError(err).Fatal("Failed to deserialize FHIR response from  gICS. Expected 'ResearchBundle'")
+		log.WithError(err).Error("Failed to deserialize FHIR response from  gICS. Expected 'QuestionnaireResponse'")
+		return nil, malformed(http.MethodGet, c.resourceUrl(resId), responseData, err)
+	}
+
+	return &qr, nil
+}
+
+func (c *GicsHttpClient) resourceUrl(resId string) string {
+	return strings.TrimSuffix(c.BaseUrl, "/") + "/" + strings.TrimPrefix(resId, "/")
+}
+
+func (c *GicsHttpClient) getResource(ctx context.Context, resId string) ([]byte, error) {
+	resReq := c.resourceUrl(resId)
+	responseData, err := c.do(ctx, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, resReq, nil)
+		if err != nil {
+			log.WithError(err).Error("Failed to create resource request")
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/fhir+json")
+		if c.Authenticator != nil {
+			if err = c.Authenticator.Authenticate(ctx, req); err != nil {
+				return nil, err
+			}
+		}
+		return req, nil
+	})
+	if err != nil {
+		log.WithError(err).Error("GET request to gICS failed for: " + resReq)
 		return nil, err
 	}
 
-	return &study, nil
+	return responseData, nil
 }
 
-func (c *GicsHttpClient) GetConsentStatus(signerId model.SignerId, domain, date string) (*fhir.Bundle, error) {
-	date = strings.Fields(date)[0]
+func (c *GicsHttpClient) GetConsentStatus(ctx context.Context, signerId model.SignerId, domain, date string) (*fhir.Bundle, error) {
+	fields := strings.Fields(date)
+	if len(fields) == 0 {
+		return nil, errors.New("missing consent date")
+	}
+	date = fields[0]
 
 	idSystem := c.IdentifierSystem + signerId.IdType
 
@@ -121,46 +177,37 @@ func (c *GicsHttpClient) GetConsentStatus(signerId model.SignerId, domain, date
 		return nil, err
 	}
 
-	response, err := c.postRequest(r)
-
+	responseData, err := c.postRequest(ctx, r)
 	if err != nil {
 		log.WithError(err).Error("POST request to gICS failed for: " + c.PolicyStatesUrl)
 		return nil, err
 	}
-	defer closeBody(response.Body)
-
-	responseData, err := io.ReadAll(response.Body)
-	if err != nil {
-		log.WithError(err).Fatal("Unable to parse gICS get consent status response")
-	}
-	if response.StatusCode != http.StatusOK {
-		err = errors.New("POST request to gICS failed: " + string(responseData))
-		log.WithField("statusCode", response.StatusCode).Error(err.Error())
-		return nil, err
-	}
 
 	bundle, err := fhir.UnmarshalBundle(responseData)
 	if err != nil {
-		log.WithError(err).Fatal("Failed to deserialize FHIR response from  gICS. Expected 'Bundle'")
-		return nil, err
+		log.WithError(err).Error("Failed to deserialize FHIR response from  gICS. Expected 'Bundle'")
+		return nil, malformed(http.MethodPost, c.PolicyStatesUrl, responseData, err)
 	}
 
 	return &bundle, nil
 }
 
-func (c *GicsHttpClient) postRequest(body []byte) (*http.Response, error) {
-	req, err := http.NewRequest(http.MethodPost, c.PolicyStatesUrl,
-		bytes.NewBuffer(body))
-	if err != nil {
-		log.WithError(err).Fatal("Failed to create POST request")
-		return nil, err
-	}
-	req.Header.Set("Content-Type", "application/fhir+json")
-	if c.Auth != nil {
-		req.SetBasicAuth(c.Auth.User, c.Auth.Password)
-	}
-
-	return http.DefaultClient.Do(req)
+func (c *GicsHttpClient) postRequest(ctx context.Context, body []byte) ([]byte, error) {
+	return c.do(ctx, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.PolicyStatesUrl,
+			bytes.NewBuffer(body))
+		if err != nil {
+			log.WithError(err).Error("Failed to create POST request")
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/fhir+json")
+		if c.Authenticator != nil {
+			if err = c.Authenticator.Authenticate(ctx, req); err != nil {
+				return nil, err
+			}
+		}
+		return req, nil
+	})
 }
 
 func closeBody(body io.ReadCloser) {
@@ -3,6 +3,7 @@ package client
 import (
 	"consent-to-fhir/pkg/config"
 	"consent-to-fhir/pkg/model"
+	"context"
 	"github.com/samply/golang-fhir-models/fhir-models/fhir"
 	"github.com/stretchr/testify/assert"
 	"net/http"
@@ -23,7 +24,7 @@ func TestGetConsentDomain(t *testing.T) {
 		Fhir: config.Fhir{Base: s.URL},
 	}})
 
-	actual, _ := c.GetConsentDomain("/ResearchStudy/" + id)
+	actual, _ := c.GetConsentDomain(context.Background(), "/ResearchStudy/"+id)
 
 	assert.Equal(t, id, *actual.Id)
 }
@@ -43,13 +44,25 @@ func TestGetConsentStatus(t *testing.T) {
 		Fhir: config.Fhir{Base: s.URL},
 	}})
 
-	actual, _ := c.GetConsentStatus(model.SignerId{Id: "test"}, "domain", "2024-01-01")
+	actual, _ := c.GetConsentStatus(context.Background(), model.SignerId{Id: "test"}, "domain", "2024-01-01")
 	resource, _ := actual.Entry[0].Resource.MarshalJSON()
 	consent, _ := fhir.UnmarshalConsent(resource)
 
 	assert.Equal(t, testId, *consent.Id)
 }
 
+func TestGetConsentStatus_NoDate(t *testing.T) {
+
+	c := NewGicsClient(config.AppConfig{Gics: config.Gics{
+		Fhir: config.Fhir{Base: "http://localhost"},
+	}})
+
+	actual, err := c.GetConsentStatus(context.Background(), model.SignerId{Id: "test"}, "domain", " ")
+
+	assert.Error(t, err)
+	assert.Nil(t, actual)
+}
+
 func withTestServer(response []byte, code int) *httptest.Server {
 	return httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
 
@@ -57,3 +70,34 @@ func withTestServer(response []byte, code int) *httptest.Server {
 		_, _ = res.Write(response)
 	}))
 }
+
+func TestGetQuestionnaireResponse(t *testing.T) {
+
+	id := "test-id"
+	res, _ := fhir.QuestionnaireResponse{Id: &id, Status: fhir.QuestionnaireResponseStatusCompleted}.MarshalJSON()
+
+	s := withTestServer(res, 200)
+	defer s.Close()
+
+	c := NewGicsClient(config.AppConfig{Gics: config.Gics{
+		Fhir: config.Fhir{Base: s.URL},
+	}})
+
+	actual, _ := c.GetQuestionnaireResponse(context.Background(), "QuestionnaireResponse/"+id)
+
+	assert.Equal(t, id, *actual.Id)
+}
+
+func TestGetQuestionnaireResponse_NotFound(t *testing.T) {
+
+	s := withTestServer([]byte("not found"), 404)
+	defer s.Close()
+
+	c := NewGicsClient(config.AppConfig{Gics: config.Gics{
+		Fhir: config.Fhir{Base: s.URL},
+	}})
+
+	_, err := c.GetQuestionnaireResponse(context.Background(), "QuestionnaireResponse/test-id")
+
+	assert.Error(t, err)
+}
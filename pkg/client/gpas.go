@@ -0,0 +1,123 @@
+package client
+
+import (
+	"bytes"
+	"consent-to-fhir/pkg/config"
+	"consent-to-fhir/pkg/model"
+	"context"
+	"fmt"
+	"github.com/samply/golang-fhir-models/fhir-models/fhir"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// GpasHttpClient resolves signer ids via the gPAS TTP-FHIR $pseudonymize operation
+type GpasHttpClient struct {
+	Auth            Authenticator
+	PseudonymizeUrl string
+	Domain          string
+	AllowCreate     bool
+	System          string
+	Timeout         time.Duration
+	Retry           config.Retry
+	HttpClient      *http.Client
+}
+
+func NewGpasClient(config config.AppConfig) *GpasHttpClient {
+	httpClient, err := NewHttpClient(config.Gpas.Fhir.Tls)
+	if err != nil {
+		log.WithError(err).Fatal("Invalid gPAS TLS configuration")
+	}
+	auth, err := NewAuthenticator(config.Gpas.Fhir.Auth)
+	if err != nil {
+		log.WithError(err).Fatal("Invalid gPAS OAuth TLS configuration")
+	}
+
+	return &GpasHttpClient{
+		Auth:            auth,
+		PseudonymizeUrl: strings.TrimSuffix(config.Gpas.Fhir.Base, "/") + "/$pseudonymize",
+		Domain:          config.Gpas.Domain,
+		AllowCreate:     config.Gpas.AllowCreate,
+		System:          config.App.Mapper.Identity.System,
+		Timeout:         config.Gpas.Fhir.Timeout,
+		Retry:           config.Gpas.Retry,
+		HttpClient:      httpClient,
+	}
+}
+
+func (c *GpasHttpClient) Resolve(ctx context.Context, signerId model.SignerId) (*fhir.Identifier, error) {
+	fhirRequest := fhir.Parameters{
+		Parameter: []fhir.ParametersParameter{
+			{
+				Name:        "target",
+				ValueString: &c.Domain,
+			},
+			{
+				Name:        "original",
+				ValueString: &signerId.Id,
+			},
+			{
+				Name:         "allowCreate",
+				ValueBoolean: &c.AllowCreate,
+			},
+		},
+	}
+	body, err := fhirRequest.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	responseData, err := requester{
+		service:       "gPAS",
+		authenticator: c.Auth,
+		httpClient:    c.HttpClient,
+		timeout:       c.Timeout,
+		retry:         c.Retry,
+	}.do(ctx, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.PseudonymizeUrl, bytes.NewBuffer(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/fhir+json")
+		if c.Auth != nil {
+			if err = c.Auth.Authenticate(ctx, req); err != nil {
+				return nil, err
+			}
+		}
+		return req, nil
+	})
+	if err != nil {
+		log.WithError(err).Error("POST request to gPAS failed for: " + c.PseudonymizeUrl)
+		return nil, err
+	}
+
+	params, err := fhir.UnmarshalParameters(responseData)
+	if err != nil {
+		log.WithError(err).Error("Failed to deserialize FHIR response from gPAS. Expected 'Parameters'")
+		return nil, malformed(http.MethodPost, c.PseudonymizeUrl, responseData, err)
+	}
+
+	return c.getPseudonym(params, signerId)
+}
+
+// getPseudonym returns the pseudonym part of the $pseudonymize response
+func (c *GpasHttpClient) getPseudonym(params fhir.Parameters, signerId model.SignerId) (*fhir.Identifier, error) {
+	for _, p := range params.Parameter {
+		if p.Name != "pseudonym" {
+			continue
+		}
+		for _, part := range p.Part {
+			if part.Name == "pseudonym" && part.ValueIdentifier != nil && part.ValueIdentifier.Value != nil {
+				psn := *part.ValueIdentifier
+				if c.System != "" {
+					psn.System = &c.System
+				}
+				return &psn, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrUnknownIdentity, signerId.IdType)
+}
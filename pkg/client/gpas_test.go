@@ -0,0 +1,130 @@
+package client
+
+import (
+	"consent-to-fhir/pkg/config"
+	"consent-to-fhir/pkg/model"
+	"context"
+	"github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func createPseudonymizeResponse(original, psn string) []byte {
+	target := "MII"
+	system := "https://ths-greifswald.de/gpas"
+	b, _ := fhir.Parameters{Parameter: []fhir.ParametersParameter{{
+		Name: "pseudonym",
+		Part: []fhir.ParametersParameter{
+			{Name: "original", ValueIdentifier: &fhir.Identifier{Value: &original}},
+			{Name: "target", ValueIdentifier: &fhir.Identifier{Value: &target}},
+			{Name: "pseudonym", ValueIdentifier: &fhir.Identifier{
+				System: &system,
+				Value:  &psn,
+			}},
+		},
+	}}}.MarshalJSON()
+	return b
+}
+
+func TestGpasResolve(t *testing.T) {
+	cases := []struct {
+		name           string
+		system         string
+		expectedSystem string
+	}{
+		{
+			name:           "gpas system",
+			expectedSystem: "https://ths-greifswald.de/gpas",
+		},
+		{
+			name:           "configured system",
+			system:         "https://fhir.diz.uni-marburg.de/sid/patient-psn",
+			expectedSystem: "https://fhir.diz.uni-marburg.de/sid/patient-psn",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := withTestServer(createPseudonymizeResponse("42", "psn-42"), 200)
+			defer s.Close()
+
+			r := NewGpasClient(config.AppConfig{
+				App:  config.App{Mapper: config.Mapper{Identity: config.Identity{System: c.system}}},
+				Gpas: config.Gpas{Fhir: config.Fhir{Base: s.URL}, Domain: "MII"},
+			})
+
+			actual, err := r.Resolve(context.Background(), model.SignerId{IdType: "Patienten-ID", Id: "42"})
+
+			assert.NoError(t, err)
+			assert.Equal(t, "psn-42", *actual.Value)
+			assert.Equal(t, c.expectedSystem, *actual.System)
+		})
+	}
+}
+
+func TestGpasResolve_Error(t *testing.T) {
+	cases := []struct {
+		name     string
+		code     int
+		body     string
+		attempts int32
+		expected error
+	}{
+		{"rejected", http.StatusUnprocessableEntity, `{"resourceType":"OperationOutcome"}`, 1, ErrRejected},
+		{"unauthorized", http.StatusUnauthorized, "", 1, ErrUnauthorized},
+		{"unavailable", http.StatusServiceUnavailable, "", 3, ErrTransient},
+		{"malformed", http.StatusOK, "{", 1, ErrMalformed},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var attempts atomic.Int32
+			s := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+				attempts.Add(1)
+				res.WriteHeader(c.code)
+				_, _ = res.Write([]byte(c.body))
+			}))
+			defer s.Close()
+
+			r := NewGpasClient(config.AppConfig{Gpas: config.Gpas{
+				Fhir:   config.Fhir{Base: s.URL},
+				Retry:  config.Retry{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond},
+				Domain: "MII",
+			}})
+
+			_, err := r.Resolve(context.Background(), model.SignerId{IdType: "Patienten-ID", Id: "42"})
+
+			var reqErr *RequestError
+			assert.ErrorAs(t, err, &reqErr)
+			assert.ErrorIs(t, err, c.expected)
+			assert.Equal(t, c.attempts, attempts.Load())
+		})
+	}
+}
+
+func TestGpasResolve_Unreachable(t *testing.T) {
+	s := httptest.NewServer(http.NotFoundHandler())
+	s.Close()
+
+	r := NewGpasClient(config.AppConfig{Gpas: config.Gpas{Fhir: config.Fhir{Base: s.URL}, Domain: "MII"}})
+
+	_, err := r.Resolve(context.Background(), model.SignerId{IdType: "Patienten-ID", Id: "42"})
+
+	assert.ErrorIs(t, err, ErrTransient)
+}
+
+func TestGpasResolve_Unknown(t *testing.T) {
+	b, _ := fhir.Parameters{}.MarshalJSON()
+	s := withTestServer(b, 200)
+	defer s.Close()
+
+	r := NewGpasClient(config.AppConfig{Gpas: config.Gpas{Fhir: config.Fhir{Base: s.URL}, Domain: "MII"}})
+
+	_, err := r.Resolve(context.Background(), model.SignerId{IdType: "Patienten-ID", Id: "42"})
+
+	assert.ErrorIs(t, err, ErrUnknownIdentity)
+}
@@ -0,0 +1,83 @@
+package client
+
+import (
+	"consent-to-fhir/pkg/config"
+	"consent-to-fhir/pkg/model"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	GpasResolver = "gpas"
+	FileResolver = "file"
+)
+
+var ErrUnknownIdentity = errors.New("no pseudonym found for signer id")
+
+// IdentityResolver translates a signer id into a pseudonym identifier of the target domain
+type IdentityResolver interface {
+	Resolve(ctx context.Context, signerId model.SignerId) (*fhir.Identifier, error)
+}
+
+// NewIdentityResolver creates the configured resolver. Returns nil, if signer ids should not be resolved
+func NewIdentityResolver(c config.AppConfig) (IdentityResolver, error) {
+	identity := c.App.Mapper.Identity
+
+	switch identity.Resolver {
+	case "":
+		return nil, nil
+	case GpasResolver:
+		return NewGpasClient(c), nil
+	case FileResolver:
+		return NewFileResolver(identity.File, identity.System)
+	default:
+		return nil, fmt.Errorf("unknown identity resolver '%s'", identity.Resolver)
+	}
+}
+
+// MemoryResolver resolves signer ids from a map of pseudonyms
+type MemoryResolver struct {
+	Pseudonyms map[string]string
+	System     string
+}
+
+func (r *MemoryResolver) Resolve(_ context.Context, signerId model.SignerId) (*fhir.Identifier, error) {
+	psn, ok := r.Pseudonyms[signerId.Id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownIdentity, signerId.IdType)
+	}
+
+	return &fhir.Identifier{System: &r.System, Value: &psn}, nil
+}
+
+// NewFileResolver loads pseudonyms from a CSV file with 'original,pseudonym' records
+func NewFileResolver(path, system string) (*MemoryResolver, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	pseudonyms := make(map[string]string)
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = 2
+	reader.TrimLeadingSpace = true
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read pseudonym file '%s': %w", path, err)
+		}
+		pseudonyms[strings.TrimSpace(record[0])] = strings.TrimSpace(record[1])
+	}
+
+	return &MemoryResolver{Pseudonyms: pseudonyms, System: system}, nil
+}
@@ -0,0 +1,81 @@
+package client
+
+import (
+	"consent-to-fhir/pkg/config"
+	"consent-to-fhir/pkg/model"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestMemoryResolver_Resolve(t *testing.T) {
+	r := &MemoryResolver{Pseudonyms: map[string]string{"42": "psn-42"}, System: "https://ths-greifswald.de/gpas"}
+
+	actual, err := r.Resolve(context.Background(), model.SignerId{IdType: "Patienten-ID", Id: "42"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "psn-42", *actual.Value)
+	assert.Equal(t, "https://ths-greifswald.de/gpas", *actual.System)
+
+	_, err = r.Resolve(context.Background(), model.SignerId{IdType: "Patienten-ID", Id: "1"})
+	assert.ErrorIs(t, err, ErrUnknownIdentity)
+}
+
+func TestNewFileResolver(t *testing.T) {
+	r, err := NewFileResolver("testdata/pseudonyms.csv", "https://fhir.diz.uni-marburg.de/sid/patient-psn")
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"42": "psn-42", "43": "psn-43"}, r.Pseudonyms)
+}
+
+func TestNewIdentityResolver(t *testing.T) {
+	cases := []struct {
+		name     string
+		identity config.Identity
+		expected any
+		err      bool
+	}{
+		{
+			name:     "none",
+			identity: config.Identity{},
+			expected: nil,
+		},
+		{
+			name:     "gpas",
+			identity: config.Identity{Resolver: GpasResolver},
+			expected: &GpasHttpClient{},
+		},
+		{
+			name:     "file",
+			identity: config.Identity{Resolver: FileResolver, File: "testdata/pseudonyms.csv"},
+			expected: &MemoryResolver{},
+		},
+		{
+			name:     "missing file",
+			identity: config.Identity{Resolver: FileResolver, File: "testdata/missing.csv"},
+			err:      true,
+		},
+		{
+			name:     "unknown",
+			identity: config.Identity{Resolver: "epix"},
+			err:      true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			actual, err := NewIdentityResolver(config.AppConfig{App: config.App{Mapper: config.Mapper{Identity: c.identity}}})
+
+			if c.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			if c.expected == nil {
+				assert.Nil(t, actual)
+			} else {
+				assert.IsType(t, c.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package client
+
+import (
+	"consent-to-fhir/pkg/config"
+	"context"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// requester sends requests to a TTP-FHIR service with a timeout per attempt, retries and an optional circuit breaker
+type requester struct {
+	service       string
+	authenticator Authenticator
+	httpClient    *http.Client
+	timeout       time.Duration
+	retry         config.Retry
+	breaker       *CircuitBreaker
+}
+
+// do sends a request created by newRequest and returns the body of a successful response. Transient failures
+// (network errors, timeouts, 5xx and 429) are retried with jittered exponential backoff, until ctx is done. Failures
+// after the last attempt are recorded by the circuit breaker and the returned error wraps ErrCircuitOpen, if the
+// breaker has been opened
+func (c requester) do(ctx context.Context, newRequest func(ctx context.Context) (*http.Request, error)) ([]byte, error) {
+	if err := c.breaker.Allow(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrTransient, err)
+	}
+
+	attempts := max(c.retry.MaxAttempts, 1)
+	var lastErr *RequestError
+	for attempt := 1; ; attempt++ {
+		body, header, err := c.send(ctx, newRequest)
+		if err == nil {
+			c.breaker.Success()
+			return body, nil
+		}
+		if ctx.Err() != nil {
+			// canceled by caller
+			return nil, fmt.Errorf("%w: %w", ctx.Err(), err)
+		}
+		if !errors.As(err, &lastErr) {
+			return nil, err
+		}
+		if lastErr.Class == ErrUnauthorized {
+			// request a new token next time
+			if a, ok := c.authenticator.(invalidator); ok {
+				a.Invalidate()
+			}
+		}
+		if lastErr.Class != ErrTransient {
+			// service is available
+			c.breaker.Success()
+			return nil, lastErr
+		}
+		if attempt >= attempts {
+			break
+		}
+
+		wait := c.backoff(attempt)
+		if after, ok := retryAfter(header.Get("Retry-After"), time.Now()); ok {
+			wait = after
+			if c.retry.MaxBackoff > 0 {
+				wait = min(wait, c.retry.MaxBackoff)
+			}
+		}
+		log.WithError(lastErr).WithFields(log.Fields{"attempt": attempt, "wait": wait}).
+			Warn(c.service + " request failed. Retrying")
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, fmt.Errorf("%w: %w", ctx.Err(), lastErr)
+		case <-timer.C:
+		}
+	}
+
+	if c.breaker.Failure() {
+		log.WithField("duration", c.breaker.OpenDuration).Warn(c.service + " circuit breaker opened")
+		return nil, fmt.Errorf("%w: %w", ErrCircuitOpen, lastErr)
+	}
+	return nil, lastErr
+}
+
+// send executes a single request, limited by the configured timeout, and returns the body of a successful response
+func (c requester) send(ctx context.Context, newRequest func(ctx context.Context) (*http.Request, error)) ([]byte, http.Header, error) {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	req, err := newRequest(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	response, err := httpClientOrDefault(c.httpClient).Do(req)
+	if err != nil {
+		return nil, nil, &RequestError{Class: ErrTransient, Method: req.Method, Url: req.URL.String(), Err: err}
+	}
+	defer closeBody(response.Body)
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, nil, &RequestError{Class: ErrTransient, Method: req.Method, Url: req.URL.String(),
+			StatusCode: response.StatusCode, Err: err}
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, response.Header, responseError(req, response.StatusCode, body)
+	}
+
+	return body, nil, nil
+}
+
+// backoff returns the jittered wait time after the given attempt, between half and the full exponential backoff
+func (c requester) backoff(attempt int) time.Duration {
+	d := c.retry.InitialBackoff
+	for i := 1; i < attempt && (c.retry.MaxBackoff <= 0 || d < c.retry.MaxBackoff); i++ {
+		d *= 2
+	}
+	if c.retry.MaxBackoff > 0 {
+		d = min(d, c.retry.MaxBackoff)
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+func isTransientStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code >= http.StatusInternalServerError
+}
+
+// retryAfter parses the Retry-After header value, given in seconds or as HTTP date
+func retryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0), true
+	}
+	return 0, false
+}
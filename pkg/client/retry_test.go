@@ -0,0 +1,172 @@
+package client
+
+import (
+	"consent-to-fhir/pkg/config"
+	"consent-to-fhir/pkg/model"
+	"context"
+	"github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGetConsentStatus_Retry(t *testing.T) {
+
+	b, _ := fhir.Bundle{}.MarshalJSON()
+	cases := []struct {
+		name     string
+		failures int32
+		code     int
+		attempts int32
+		wantErr  bool
+	}{
+		{"recovered", 2, http.StatusServiceUnavailable, 3, false},
+		{"exhausted", 3, http.StatusBadGateway, 3, true},
+		{"tooManyRequests", 1, http.StatusTooManyRequests, 2, false},
+		{"notTransient", 1, http.StatusBadRequest, 1, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var attempts atomic.Int32
+			s := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+				if attempts.Add(1) <= c.failures {
+					res.Header().Set("Retry-After", "0")
+					res.WriteHeader(c.code)
+					return
+				}
+				_, _ = res.Write(b)
+			}))
+			defer s.Close()
+
+			client := NewGicsClient(config.AppConfig{Gics: config.Gics{
+				Fhir:  config.Fhir{Base: s.URL},
+				Retry: config.Retry{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond},
+			}})
+
+			_, err := client.GetConsentStatus(context.Background(), model.SignerId{Id: "test"}, "domain", "2024-01-01")
+
+			assert.Equal(t, c.wantErr, err != nil)
+			assert.Equal(t, c.attempts, attempts.Load())
+		})
+	}
+}
+
+func TestGetConsentDomain_CircuitOpen(t *testing.T) {
+
+	var attempts atomic.Int32
+	s := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		attempts.Add(1)
+		res.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer s.Close()
+
+	c := NewGicsClient(config.AppConfig{Gics: config.Gics{
+		Fhir:           config.Fhir{Base: s.URL},
+		Retry:          config.Retry{MaxAttempts: 2},
+		CircuitBreaker: config.CircuitBreaker{FailureThreshold: 1, OpenDuration: time.Minute},
+	}})
+
+	_, err := c.GetConsentDomain(context.Background(), "ResearchStudy/1")
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, int32(2), attempts.Load())
+
+	// rejected without request
+	_, err = c.GetConsentDomain(context.Background(), "ResearchStudy/1")
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, int32(2), attempts.Load())
+}
+
+func TestBackoff(t *testing.T) {
+
+	c := requester{retry: config.Retry{InitialBackoff: 100 * time.Millisecond, MaxBackoff: 300 * time.Millisecond}}
+
+	cases := []struct {
+		attempt  int
+		min, max time.Duration
+	}{
+		{1, 50 * time.Millisecond, 100 * time.Millisecond},
+		{2, 100 * time.Millisecond, 200 * time.Millisecond},
+		{5, 150 * time.Millisecond, 300 * time.Millisecond},
+	}
+
+	for _, tc := range cases {
+		d := c.backoff(tc.attempt)
+		assert.GreaterOrEqual(t, d, tc.min)
+		assert.LessOrEqual(t, d, tc.max)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		name     string
+		value    string
+		expected time.Duration
+		ok       bool
+	}{
+		{"empty", "", 0, false},
+		{"seconds", "3", 3 * time.Second, true},
+		{"date", now.Add(time.Minute).Format(http.TimeFormat), time.Minute, true},
+		{"invalid", "soon", 0, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			d, ok := retryAfter(c.value, now)
+
+			assert.Equal(t, c.ok, ok)
+			assert.Equal(t, c.expected, d)
+		})
+	}
+}
+
+func TestGetConsentStatus_Timeout(t *testing.T) {
+
+	var attempts atomic.Int32
+	done := make(chan struct{})
+	s := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		attempts.Add(1)
+		<-done
+	}))
+	defer s.Close()
+	defer close(done)
+
+	c := NewGicsClient(config.AppConfig{Gics: config.Gics{
+		Fhir:  config.Fhir{Base: s.URL, Timeout: 10 * time.Millisecond},
+		Retry: config.Retry{MaxAttempts: 2},
+	}})
+
+	_, err := c.GetConsentStatus(context.Background(), model.SignerId{Id: "test"}, "domain", "2024-01-01")
+
+	assert.ErrorIs(t, err, ErrTransient)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, int32(2), attempts.Load())
+}
+
+func TestGetConsentStatus_Canceled(t *testing.T) {
+
+	s := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer s.Close()
+
+	c := NewGicsClient(config.AppConfig{Gics: config.Gics{
+		Fhir:           config.Fhir{Base: s.URL},
+		Retry:          config.Retry{MaxAttempts: 3, InitialBackoff: time.Minute},
+		CircuitBreaker: config.CircuitBreaker{FailureThreshold: 1},
+	}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	_, err := c.GetConsentStatus(ctx, model.SignerId{Id: "test"}, "domain", "2024-01-01")
+
+	assert.ErrorIs(t, err, context.Canceled)
+	// cancellation is not a gICS failure
+	assert.NoError(t, c.Breaker.Allow())
+}
@@ -0,0 +1,131 @@
+package client
+
+import (
+	"consent-to-fhir/pkg/config"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNewHttpClient_Default(t *testing.T) {
+
+	c, err := NewHttpClient(config.Tls{})
+
+	assert.NoError(t, err)
+	assert.Same(t, http.DefaultClient, c)
+}
+
+func TestNewHttpClient_Invalid(t *testing.T) {
+
+	dir := t.TempDir()
+	cert, key := writeClientCertificate(t, dir)
+
+	cases := []struct {
+		name string
+		tls  config.Tls
+	}{
+		{"minVersion", config.Tls{MinVersion: "1.4"}},
+		{"missingCaFile", config.Tls{CaFile: filepath.Join(dir, "missing.pem")}},
+		{"emptyCaFile", config.Tls{CaFile: key}},
+		{"missingKey", config.Tls{CertFile: cert}},
+		{"invalidKey", config.Tls{CertFile: cert, KeyFile: cert}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := NewHttpClient(c.tls)
+
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestGetConsentDomain_MutualTls(t *testing.T) {
+
+	dir := t.TempDir()
+	cert, key := writeClientCertificate(t, dir)
+
+	id := "test-id"
+	study, _ := fhir.ResearchStudy{Id: &id}.MarshalJSON()
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		_, _ = res.Write(study)
+	}))
+	clientCas := x509.NewCertPool()
+	pemData, _ := os.ReadFile(cert)
+	clientCas.AppendCertsFromPEM(pemData)
+	s.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCas}
+	s.StartTLS()
+	defer s.Close()
+
+	ca := filepath.Join(dir, "ca.pem")
+	_ = os.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.Certificate().Raw}), 0o600)
+
+	cases := []struct {
+		name    string
+		tls     config.Tls
+		wantErr bool
+	}{
+		{"systemRoots", config.Tls{CertFile: cert, KeyFile: key}, true},
+		{"noClientCertificate", config.Tls{CaFile: ca}, true},
+		{"mutual", config.Tls{CaFile: ca, CertFile: cert, KeyFile: key, MinVersion: "1.2"}, false},
+		{"serverName", config.Tls{CaFile: ca, CertFile: cert, KeyFile: key, ServerName: "example.com"}, false},
+		{"invalidServerName", config.Tls{CaFile: ca, CertFile: cert, KeyFile: key, ServerName: "gics.local"}, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			client := NewGicsClient(config.AppConfig{Gics: config.Gics{
+				Fhir: config.Fhir{Base: s.URL, Tls: c.tls},
+			}})
+
+			actual, err := client.GetConsentDomain(context.Background(), "ResearchStudy/test-id")
+
+			if c.wantErr {
+				assert.ErrorIs(t, err, ErrTransient)
+			} else if assert.NoError(t, err) {
+				assert.Equal(t, "test-id", *actual.Id)
+			}
+		})
+	}
+}
+
+// writeClientCertificate creates a self-signed client certificate and returns the PEM file paths
+func writeClientCertificate(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "consent-to-fhir"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	assert.NoError(t, err)
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	assert.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	assert.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}), 0o600))
+	return certFile, keyFile
+}
@@ -7,38 +7,157 @@ import (
 	"github.com/knadh/koanf/v2"
 	"regexp"
 	"strings"
+	"time"
 )
 
 type AppConfig struct {
 	App   App   `koanf:"app"`
 	Kafka Kafka `koanf:"kafka"`
 	Gics  Gics  `koanf:"gics"`
+	Gpas  Gpas  `koanf:"gpas"`
 }
 
 type App struct {
-	Name     string `koanf:"name"`
-	LogLevel string `koanf:"log-level"`
-	Mapper   Mapper `koanf:"mapper"`
+	Name           string    `koanf:"name"`
+	LogLevel       string    `koanf:"log-level"`
+	MetricsAddress string    `koanf:"metrics-address"`
+	Mapper         Mapper    `koanf:"mapper"`
+	Scheduler      Scheduler `koanf:"scheduler"`
+}
+
+type Scheduler struct {
+	Enabled     bool          `koanf:"enabled"`
+	Queue       string        `koanf:"queue"`
+	Interval    time.Duration `koanf:"interval"`
+	MaxAttempts int           `koanf:"max-attempts"`
 }
 
 type Mapper struct {
-	ConsentSystem *string           `koanf:"consent-system"`
-	PatientSystem *string           `koanf:"patient-system"`
-	DomainSystem  *string           `koanf:"domain-system"`
-	Profiles      map[string]string `koanf:"profiles"`
+	ConsentSystem   *string           `koanf:"consent-system"`
+	PatientSystem   *string           `koanf:"patient-system"`
+	DomainSystem    *string           `koanf:"domain-system"`
+	Profiles        map[string]string `koanf:"profiles"`
+	Domains         map[string]Domain `koanf:"domains"`
+	ConceptMaps     []ConceptMap      `koanf:"concept-maps"`
+	Templates       []Template        `koanf:"templates"`
+	StrictTemplates bool              `koanf:"strict-templates"`
+	Withdrawal      string            `koanf:"withdrawal"`
+	SkipUnchanged   bool              `koanf:"skip-unchanged"`
+	Provenance      bool              `koanf:"provenance"`
+	KeepSource      bool              `koanf:"keep-source"`
+	SourceSystem    *string           `koanf:"source-system"`
+	Identity        Identity          `koanf:"identity"`
+	ConsentId       ConsentId         `koanf:"consent-id"`
+	OutputVersion   string            `koanf:"output-version"`
+	History         History           `koanf:"history"`
+	RestrictDomains bool              `koanf:"restrict-domains"`
+}
+
+type History struct {
+	Enabled  bool   `koanf:"enabled"`
+	Store    string `koanf:"store"`
+	Previous string `koanf:"previous"`
+}
+
+type ConsentId struct {
+	Strategy     string `koanf:"strategy"`
+	Secret       string `koanf:"secret"`
+	Namespace    string `koanf:"namespace"`
+	MigrateFrom  string `koanf:"migrate-from"`
+	MigrateUntil string `koanf:"migrate-until"`
+}
+
+type Identity struct {
+	Resolver string `koanf:"resolver"`
+	System   string `koanf:"system"`
+	File     string `koanf:"file"`
+}
+
+type Template struct {
+	Name            string   `koanf:"name"`
+	Version         string   `koanf:"version"`
+	PolicyUri       string   `koanf:"policy-uri"`
+	Categories      []Coding `koanf:"categories"`
+	ProvisionSystem string   `koanf:"provision-system"`
+}
+
+type Coding struct {
+	System  string `koanf:"system"`
+	Code    string `koanf:"code"`
+	Display string `koanf:"display"`
+}
+
+type ConceptMap struct {
+	File    string `koanf:"file"`
+	Domain  string `koanf:"domain"`
+	Profile string `koanf:"profile"`
+}
+
+type Domain struct {
+	Mode          string          `koanf:"mode"`
+	Policies      []PolicyMapping `koanf:"policies"`
+	Signer        Signer          `koanf:"signer"`
+	Withdrawal    string          `koanf:"withdrawal"`
+	QcPolicy      string          `koanf:"qc-policy"`
+	PatientStub   bool            `koanf:"patient-stub"`
+	Expiry        Expiry          `koanf:"expiry"`
+	OutputTopic   string          `koanf:"output-topic"`
+	Profile       string          `koanf:"profile"`
+	ConsentSystem string          `koanf:"consent-system"`
+	PatientSystem string          `koanf:"patient-system"`
+}
+
+type Expiry struct {
+	Sentinels      []string `koanf:"sentinels"`
+	ThresholdYears int      `koanf:"threshold-years"`
+}
+
+type Signer struct {
+	IdTypes []string          `koanf:"id-types"`
+	Systems map[string]string `koanf:"systems"`
+}
+
+type PolicyMapping struct {
+	Name    string `koanf:"name"`
+	Version string `koanf:"version"`
+	System  string `koanf:"system"`
+	Code    string `koanf:"code"`
+	Display string `koanf:"display"`
 }
 
 type Kafka struct {
 	BootstrapServers string `koanf:"bootstrap-servers"`
 	InputTopic       string `koanf:"input-topic"`
 	OutputTopic      string `koanf:"output-topic"`
+	OutputEncoding   string `koanf:"output-encoding"`
+	DeadLetterTopic  string `koanf:"dead-letter-topic"`
 	SecurityProtocol string `koanf:"security-protocol"`
 	Ssl              Ssl    `koanf:"ssl"`
 	NumConsumers     int    `koanf:"num-consumers"`
 }
 
 type Gics struct {
-	Fhir Fhir `koanf:"fhir"`
+	Fhir           Fhir           `koanf:"fhir"`
+	Retry          Retry          `koanf:"retry"`
+	CircuitBreaker CircuitBreaker `koanf:"circuit-breaker"`
+}
+
+type Retry struct {
+	MaxAttempts    int           `koanf:"max-attempts"`
+	InitialBackoff time.Duration `koanf:"initial-backoff"`
+	MaxBackoff     time.Duration `koanf:"max-backoff"`
+}
+
+type CircuitBreaker struct {
+	FailureThreshold int           `koanf:"failure-threshold"`
+	OpenDuration     time.Duration `koanf:"open-duration"`
+}
+
+type Gpas struct {
+	Fhir        Fhir   `koanf:"fhir"`
+	Retry       Retry  `koanf:"retry"`
+	Domain      string `koanf:"domain"`
+	AllowCreate bool   `koanf:"allow-create"`
 }
 
 type Ssl struct {
@@ -49,13 +168,32 @@ type Ssl struct {
 }
 
 type Fhir struct {
-	Base string `koanf:"base"`
-	Auth *Auth  `koanf:"auth"`
+	Base    string        `koanf:"base"`
+	Auth    *Auth         `koanf:"auth"`
+	Timeout time.Duration `koanf:"timeout"`
+	Tls     Tls           `koanf:"tls"`
+}
+
+type Tls struct {
+	CaFile     string `koanf:"ca-file"`
+	CertFile   string `koanf:"cert-file"`
+	KeyFile    string `koanf:"key-file"`
+	MinVersion string `koanf:"min-version"`
+	ServerName string `koanf:"server-name"`
 }
 
 type Auth struct {
 	User     string `koanf:"user"`
 	Password string `koanf:"password"`
+	OAuth    OAuth  `koanf:"oauth"`
+}
+
+type OAuth struct {
+	TokenUrl     string `koanf:"token-url"`
+	ClientId     string `koanf:"client-id"`
+	ClientSecret string `koanf:"client-secret"`
+	Scope        string `koanf:"scope"`
+	Tls          Tls    `koanf:"tls"`
 }
 
 func LoadConfig(path string) (*AppConfig, error) {
@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"testing"
+	"time"
 )
 
 func TestParseEnv(t *testing.T) {
@@ -59,6 +60,9 @@ func TestLoadConfig(t *testing.T) {
 	c, _ := LoadConfig(base + "/app.yml")
 
 	assert.Equal(t, c.App.Name, "consent-to-fhir")
+	assert.Equal(t, c.App.Scheduler.Interval, time.Minute)
+	assert.Equal(t, c.Gics.Retry.InitialBackoff, 500*time.Millisecond)
+	assert.Equal(t, c.Gics.CircuitBreaker.OpenDuration, 30*time.Second)
 }
 
 func TestLoadConfig_invalidPath(t *testing.T) {
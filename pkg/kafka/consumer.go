@@ -5,6 +5,7 @@ import (
 	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
 	log "github.com/sirupsen/logrus"
 	"os"
+	"time"
 )
 
 type ConsentConsumer struct {
@@ -12,6 +13,9 @@ type ConsentConsumer struct {
 	Topic    string
 	ClientId string
 	IsClosed bool
+
+	paused      []kafka.TopicPartition
+	pausedUntil time.Time
 }
 
 func NewConsumer(config config.AppConfig, clientId string) *ConsentConsumer {
@@ -80,6 +84,47 @@ func (c *ConsentConsumer) StoreOffset(msg *kafka.Message) {
 	}
 }
 
+// Pause stops fetching from the message's partition and rewinds it to the message, which is consumed
+// again after the partition has been resumed
+func (c *ConsentConsumer) Pause(msg *kafka.Message, d time.Duration) {
+	tp := []kafka.TopicPartition{{
+		Topic:     msg.TopicPartition.Topic,
+		Partition: msg.TopicPartition.Partition,
+		Offset:    msg.TopicPartition.Offset,
+	}}
+	if err := c.Consumer.Pause(tp); err != nil {
+		log.WithError(err).Error("Failed to pause partition")
+		return
+	}
+	if _, err := c.Consumer.SeekPartitions(tp); err != nil {
+		log.WithError(err).Error("Failed to rewind paused partition")
+	}
+
+	c.paused = append(c.paused, tp...)
+	c.pausedUntil = time.Now().Add(d)
+	log.WithFields(log.Fields{
+		"client-id": c.ClientId,
+		"topic":     *msg.TopicPartition.Topic,
+		"partition": msg.TopicPartition.Partition,
+		"offset":    msg.TopicPartition.Offset.String(),
+		"until":     c.pausedUntil.Format(time.RFC3339)}).
+		Warn("Partition paused")
+}
+
+// ResumeDue resumes paused partitions, once the pause has passed
+func (c *ConsentConsumer) ResumeDue(now time.Time) {
+	if len(c.paused) == 0 || now.Before(c.pausedUntil) {
+		return
+	}
+
+	if err := c.Consumer.Resume(c.paused); err != nil {
+		// partitions may have been revoked in the meantime
+		log.WithError(err).Debug("Failed to resume paused partitions")
+	}
+	log.WithField("client-id", c.ClientId).Info("Paused partitions resumed")
+	c.paused = nil
+}
+
 func check(err error) {
 	if err == nil {
 		return
@@ -0,0 +1,62 @@
+package kafka
+
+import (
+	"consent-to-fhir/pkg/model"
+	"context"
+	cKafka "github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/samply/golang-fhir-models/fhir-models/fhir"
+	log "github.com/sirupsen/logrus"
+)
+
+// NotificationHandler maps a gICS notification of a specific type to a FHIR bundle.
+// Notifications are skipped if no bundle is returned
+type NotificationHandler func(ctx context.Context, n model.Notification) (*fhir.Bundle, error)
+
+// RegisterHandler sets the handler for the notification type, replacing any existing one
+func (p *Processor) RegisterHandler(notificationType string, h NotificationHandler) {
+	p.handlers[notificationType] = h
+}
+
+func (p *Processor) registerDefaultHandlers() {
+	// consent state changes are mapped from the current consent state
+	for _, t := range []string{
+		model.AddConsent,
+		model.AddConsentWithScans,
+		model.RefuseConsent,
+		model.UpdateConsentInUse,
+		model.Revoke,
+	} {
+		p.RegisterHandler(t, p.mapper.Map)
+	}
+
+	// notifications without type information (legacy)
+	p.RegisterHandler("", p.mapper.Map)
+
+	// signer id changes are not handled: mapping them from the current consent state may select another
+	// primary signer id and thus create a new consent, orphaning the existing one
+}
+
+func skipNotification(c messageConsumer, msg *cKafka.Message, n model.Notification, reason string) {
+	skippedNotifications.Add(n.Type, 1)
+	log.WithFields(log.Fields{
+		"key":       string(msg.Key),
+		"type":      n.Type,
+		"client-id": n.ClientId,
+		"offset":    msg.TopicPartition.Offset.String()}).
+		Info(reason)
+
+	c.StoreOffset(msg)
+}
+
+// hasPolicyStates checks if policy state changes apply to the notification. Signer id changes, if a handler is
+// registered for them, and consent updates with a quality control result are never skipped: the latter do not
+// change policy states, but release consents held back by the quality control policy
+func hasPolicyStates(n model.Notification) bool {
+	return n.Type != model.AddSignerIdToSignerId && !isQcUpdate(n) &&
+		(len(n.PreviousPolicyStates) > 0 || len(n.CurrentPolicyStates) > 0)
+}
+
+// isQcUpdate checks if the notification is a consent update, which may have changed the quality control status
+func isQcUpdate(n model.Notification) bool {
+	return n.Type == model.UpdateConsentInUse && n.Context != nil && n.Context.Qc != nil
+}
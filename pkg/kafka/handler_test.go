@@ -0,0 +1,41 @@
+package kafka
+
+import (
+	"consent-to-fhir/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRegisterDefaultHandlers(t *testing.T) {
+	p := &Processor{handlers: map[string]NotificationHandler{}}
+	p.registerDefaultHandlers()
+
+	for _, notificationType := range []string{model.AddConsent, model.AddConsentWithScans, model.RefuseConsent,
+		model.UpdateConsentInUse, model.Revoke, ""} {
+		assert.Contains(t, p.handlers, notificationType)
+	}
+	assert.NotContains(t, p.handlers, model.AddSignerIdToSignerId)
+}
+
+func TestHasPolicyStates(t *testing.T) {
+	qcUpdate := createTestNotification(model.UpdateConsentInUse, true, true)
+	qcUpdate.Context = &model.Context{Qc: &model.Qc{QcPassed: true}}
+
+	cases := []struct {
+		name         string
+		notification model.Notification
+		expected     bool
+	}{
+		{"policyStates", createTestNotification(model.AddConsent, false, true), true},
+		{"noPolicyStates", model.Notification{Type: model.AddConsent}, false},
+		{"signerIdChange", createTestNotification(model.AddSignerIdToSignerId, true, true), false},
+		{"qcUpdate", qcUpdate, false},
+		{"updateWithoutQc", createTestNotification(model.UpdateConsentInUse, true, true), true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.expected, hasPolicyStates(c.notification))
+		})
+	}
+}
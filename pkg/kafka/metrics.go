@@ -0,0 +1,14 @@
+package kafka
+
+import "expvar"
+
+var (
+	// skippedNotifications counts skipped notifications by notification type
+	skippedNotifications = expvar.NewMap("notifications_skipped")
+	// unchangedNotifications counts notifications skipped due to unchanged policy states
+	unchangedNotifications = expvar.NewInt("notifications_unchanged")
+	// domainNotifications counts notifications skipped due to domain restrictions by domain
+	domainNotifications = expvar.NewMap("notifications_skipped_domain")
+	// failedNotifications counts notifications, which failed to map, by error class
+	failedNotifications = expvar.NewMap("notifications_failed")
+)
@@ -1,9 +1,16 @@
 package kafka
 
 import (
+	"consent-to-fhir/pkg/client"
 	"consent-to-fhir/pkg/config"
 	"consent-to-fhir/pkg/mapper"
+	"consent-to-fhir/pkg/model"
+	"consent-to-fhir/pkg/scheduler"
+	"context"
+	"encoding/json"
+	"errors"
 	cKafka "github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/samply/golang-fhir-models/fhir-models/fhir"
 	log "github.com/sirupsen/logrus"
 	"os"
 	"os/signal"
@@ -13,16 +20,46 @@ import (
 	"time"
 )
 
+// messageConsumer stores offsets of processed messages and pauses their partitions
+type messageConsumer interface {
+	StoreOffset(msg *cKafka.Message)
+	Pause(msg *cKafka.Message, d time.Duration)
+}
+
+// messageProducer sends mapped bundles and dead-letter messages
+type messageProducer interface {
+	SendBundle(topic string, key []byte, timestamp time.Time, bundle *fhir.Bundle, headers []cKafka.Header,
+		sigchan chan os.Signal) cKafka.Event
+	Send(topic string, key []byte, timestamp time.Time, msg []byte, headers []cKafka.Header,
+		sigchan chan os.Signal) cKafka.Event
+}
+
 type Processor struct {
-	config config.AppConfig
-	mapper *mapper.GicsMapper
+	config    config.AppConfig
+	mapper    *mapper.GicsMapper
+	handlers  map[string]NotificationHandler
+	scheduler *scheduler.Scheduler
 }
 
 func NewProcessor(config config.AppConfig) *Processor {
-	return &Processor{
-		config: config,
-		mapper: mapper.NewGicsMapper(config),
+	p := &Processor{
+		config:   config,
+		mapper:   mapper.NewGicsMapper(config),
+		handlers: make(map[string]NotificationHandler),
+	}
+	p.registerDefaultHandlers()
+
+	if config.App.Scheduler.Enabled {
+		queue, err := scheduler.NewFileQueue(config.App.Scheduler.Queue)
+		if err != nil {
+			log.WithError(err).Fatal("Failed to load expiry queue")
+		}
+		p.scheduler = scheduler.NewScheduler(queue, p.mapper.Reevaluate, nil, config.App.Scheduler.Interval)
+		p.scheduler.MaxAttempts = config.App.Scheduler.MaxAttempts
+		log.WithField("scheduled", queue.Len()).Info("Expiry scheduler created")
 	}
+
+	return p
 }
 
 func (p *Processor) Run() {
@@ -34,6 +71,21 @@ func (p *Processor) Run() {
 	producer := NewProducer(p.config.Kafka)
 	var wg sync.WaitGroup
 
+	// canceled on shutdown to abort pending gICS requests
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// re-evaluate expired consents
+	if p.scheduler != nil {
+		p.scheduler.Publish = p.publishScheduled(producer)
+		p.scheduler.DeadLetter = p.deadLetterScheduled(producer)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.scheduler.Run(ctx)
+		}()
+	}
+
 	for i := 1; i <= p.config.Kafka.NumConsumers; i++ {
 
 		wg.Add(1)
@@ -57,6 +109,7 @@ func (p *Processor) Run() {
 					return
 
 				default:
+					c.ResumeDue(time.Now())
 					msg, err := c.Consumer.ReadMessage(1 * time.Second)
 					if err == nil {
 						log.WithFields(log.Fields{
@@ -66,8 +119,7 @@ func (p *Processor) Run() {
 							"offset":    msg.TopicPartition.Offset.String()}).
 							Debug("Message received")
 
-						deliveryChan := createListener(sigchan, c, msg)
-						p.processMessages(producer, msg, deliveryChan, sigchan)
+						p.processMessages(ctx, producer, c, msg, sigchan)
 
 					} else {
 						if err.(cKafka.Error).Code() != cKafka.ErrTimedOut {
@@ -80,6 +132,7 @@ func (p *Processor) Run() {
 		}(strconv.Itoa(i))
 	}
 	<-sigchan
+	cancel()
 	close(sigchan)
 	wg.Wait()
 	log.Info("All consumers stopped. Flushing outstanding producer messages...")
@@ -91,51 +144,301 @@ func (p *Processor) Run() {
 	producer.Producer.Close()
 }
 
-func createListener(sigchan chan os.Signal, c *ConsentConsumer, msg *cKafka.Message) chan cKafka.Event {
-	listener := make(chan cKafka.Event, 1)
+// handleDelivery stores the message's offset and calls onDelivered, if not nil, once the resulting message has been
+// delivered. Failed deliveries stop the consumers. Nil events (shutdown) are ignored
+func handleDelivery(sigchan chan os.Signal, c messageConsumer, msg *cKafka.Message, e cKafka.Event, onDelivered func()) {
+	if e == nil {
+		return
+	}
+
+	if err := deliveryError(e); err != nil {
+		log.WithError(err).WithField("key", string(msg.Key)).Error("Delivery failed")
+		sigchan <- syscall.SIGINT
+		return
+	}
+
+	if ev, ok := e.(*cKafka.Message); ok {
+		log.WithFields(log.Fields{
+			"key":    string(ev.Key),
+			"offset": ev.TopicPartition.Offset,
+			"topic":  *ev.TopicPartition.Topic,
+		}).
+			Debug("Delivered message")
+	}
+	if onDelivered != nil {
+		onDelivered()
+	}
+	c.StoreOffset(msg)
+}
+
+func (p *Processor) processMessages(ctx context.Context, producer messageProducer, c messageConsumer, msg *cKafka.Message,
+	sigchan chan os.Signal) {
 
-	go func(msg *cKafka.Message) {
-		defer close(listener)
+	var n model.Notification
+	if err := json.Unmarshal(msg.Value, &n); err != nil {
+		log.WithError(err).WithField("key", string(msg.Key)).Error("Failed to parse notification")
+		return
+	}
 
-		e := <-listener
-		switch ev := e.(type) {
-		case nil:
+	domain := mapper.DomainOf(n)
+	if !mapper.DomainAllowed(p.config.App.Mapper, domain) {
+		domainNotifications.Add(domain, 1)
+		skipNotification(c, msg, n, "Consent domain not configured. Skipping")
+		return
+	}
+
+	handler, ok := p.handlers[n.Type]
+	if !ok {
+		skipNotification(c, msg, n, "Notification type not handled. Skipping")
+		return
+	}
+
+	var headers []cKafka.Header
+	if p.config.App.Mapper.SkipUnchanged && hasPolicyStates(n) {
+		changes := mapper.DiffPolicyStates(n)
+		if len(changes) == 0 {
+			unchangedNotifications.Add(1)
+			skipNotification(c, msg, n, "Policy states unchanged. Skipping")
 			return
-		case *cKafka.Error:
-			log.WithError(ev).
-				Error("Processing failed")
-			sigchan <- syscall.SIGINT
-		case *cKafka.Message:
-			if ev.TopicPartition.Error != nil {
-				log.WithError(ev.TopicPartition.Error).
-					Error("Delivery failed")
-				sigchan <- syscall.SIGINT
-			} else {
-				log.WithFields(log.Fields{
-					"key":    string(ev.Key),
-					"offset": ev.TopicPartition.Offset,
-					"topic":  *ev.TopicPartition.Topic,
-				}).
-					Debug("Delivered message")
-				c.StoreOffset(msg)
-			}
 		}
-	}(msg)
 
-	return listener
+		summary, _ := json.Marshal(changes)
+		headers = append(headers, cKafka.Header{Key: "policy-changes", Value: summary})
+		log.WithFields(log.Fields{"key": string(msg.Key), "changes": string(summary)}).Debug("Policy states changed")
+	}
+
+	bundle, err := handler(ctx, n)
+	if err != nil {
+		p.handleError(producer, c, msg, n, err, sigchan)
+		return
+	}
+	if bundle == nil {
+		skipNotification(c, msg, n, "No bundle mapped for notification. Skipping")
+		return
+	}
+	// taken right away, so the pending version is released, even if the bundle is never delivered
+	onDelivered := chain(p.versionCommit(bundle, msg.Key), p.scheduleExpiry(msg.Key, n, bundle))
+
+	if p.config.App.Mapper.Provenance {
+		err = mapper.AddProvenance(bundle, n, mapper.Source{
+			Topic:     *msg.TopicPartition.Topic,
+			Partition: msg.TopicPartition.Partition,
+			Offset:    int64(msg.TopicPartition.Offset),
+		})
+		if err != nil {
+			log.WithError(err).WithField("key", string(msg.Key)).Error("Failed to create Provenance resource")
+			return
+		}
+	}
+
+	if err = p.convertBundle(bundle); err != nil {
+		log.WithError(err).WithField("key", string(msg.Key)).Error("Failed to convert bundle to FHIR R5")
+		return
+	}
+
+	e := producer.SendBundle(p.outputTopic(domain), msg.Key, msg.Timestamp, bundle, headers, sigchan)
+	handleDelivery(sigchan, c, msg, e, onDelivered)
 }
 
-func (p *Processor) processMessages(producer *FhirProducer, msg *cKafka.Message,
-	deliveryChan chan cKafka.Event, sigchan chan os.Signal) {
+// versionCommit returns the callback, which records the bundle's consent version in the history after delivery.
+// Returns nil, if there is no new consent version
+func (p *Processor) versionCommit(bundle *fhir.Bundle, key []byte) func() {
+	if p.mapper == nil {
+		return nil
+	}
+	commit := p.mapper.VersionCommit(bundle)
+	if commit == nil {
+		return nil
+	}
 
-	bundle := p.mapper.Process(msg.Value)
-	if bundle == nil {
+	return func() {
+		if err := commit(); err != nil {
+			log.WithError(err).WithField("key", string(key)).Error("Failed to record consent version")
+		}
+	}
+}
 
-		deliveryChan <- nil
+// scheduleExpiry returns the callback, which queues the bundle's next provision period end after delivery.
+// Returns nil, if the scheduler is disabled
+func (p *Processor) scheduleExpiry(key []byte, n model.Notification, bundle *fhir.Bundle) func() {
+	if p.scheduler == nil {
+		return nil
+	}
+	schedule := p.scheduler.Schedule(key, n, bundle, time.Now())
+	if schedule == nil {
+		return nil
+	}
+
+	return func() {
+		if err := schedule(); err != nil {
+			log.WithError(err).WithField("key", string(key)).Error("Failed to schedule consent expiry")
+		}
+	}
+}
+
+// chain returns a callback, which calls all non-nil callbacks in order. Returns nil, if there are none
+func chain(callbacks ...func()) func() {
+	var fns []func()
+	for _, f := range callbacks {
+		if f != nil {
+			fns = append(fns, f)
+		}
+	}
+	if len(fns) == 0 {
+		return nil
+	}
+
+	return func() {
+		for _, f := range fns {
+			f()
+		}
+	}
+}
+
+// outputTopic returns the domain's output topic. Empty, if the default output topic is used
+func (p *Processor) outputTopic(domain string) string {
+	return p.config.App.Mapper.Domains[domain].OutputTopic
+}
+
+// handleError pauses consumption on transient and authorization errors, so the message is processed again
+// later. Other errors are sent to the dead-letter topic, if configured
+func (p *Processor) handleError(producer messageProducer, c messageConsumer, msg *cKafka.Message,
+	n model.Notification, err error, sigchan chan os.Signal) {
+
+	if errors.Is(err, context.Canceled) {
+		// shutdown, message is processed again after restart
+		log.WithField("key", string(msg.Key)).Info("Processing canceled")
 		return
 	}
 
-	producer.SendBundle(msg.Key, msg.Timestamp, bundle, deliveryChan, sigchan)
+	class := errorClass(err)
+	fields := log.Fields{"key": string(msg.Key), "type": n.Type, "class": class}
+	if errors.Is(err, client.ErrTransient) || errors.Is(err, client.ErrUnauthorized) {
+		log.WithError(err).WithFields(fields).Warn("gICS unavailable. Pausing consumption")
+		c.Pause(msg, p.pauseDuration())
+		return
+	}
+
+	failedNotifications.Add(class, 1)
+	topic := p.config.Kafka.DeadLetterTopic
+	if topic == "" {
+		log.WithError(err).WithFields(fields).Error("Failed to map consent")
+		return
+	}
+
+	log.WithError(err).WithFields(fields).WithField("topic", topic).
+		Error("Failed to map consent. Sending to dead-letter topic")
+	headers := []cKafka.Header{
+		{Key: "error", Value: []byte(err.Error())},
+		{Key: "error-class", Value: []byte(class)},
+		{Key: "source-topic", Value: []byte(*msg.TopicPartition.Topic)},
+		{Key: "source-partition", Value: []byte(strconv.Itoa(int(msg.TopicPartition.Partition)))},
+		{Key: "source-offset", Value: []byte(msg.TopicPartition.Offset.String())},
+	}
+	e := producer.Send(topic, msg.Key, msg.Timestamp, msg.Value, headers, sigchan)
+	handleDelivery(sigchan, c, msg, e, nil)
+}
+
+// errorClass returns the class name of a mapping error
+func errorClass(err error) string {
+	switch {
+	case errors.Is(err, client.ErrTransient):
+		return "transient"
+	case errors.Is(err, client.ErrUnauthorized):
+		return "unauthorized"
+	case errors.Is(err, client.ErrNotFound):
+		return "not-found"
+	case errors.Is(err, client.ErrMalformed):
+		return "malformed"
+	case errors.Is(err, client.ErrRejected):
+		return "rejected"
+	default:
+		return "mapping"
+	}
+}
+
+// pauseDuration returns how long consumption is paused while gICS is unavailable
+func (p *Processor) pauseDuration() time.Duration {
+	if d := p.config.Gics.CircuitBreaker.OpenDuration; d > 0 {
+		return d
+	}
+	return 30 * time.Second
+}
+
+// convertBundle converts the bundle to the configured output FHIR version
+func (p *Processor) convertBundle(bundle *fhir.Bundle) error {
+	if p.config.App.Mapper.OutputVersion == mapper.OutputR5 {
+		return mapper.ConvertBundleR5(bundle)
+	}
+	return nil
+}
+
+// publishScheduled sends re-evaluated bundles of the expiry scheduler and waits for their delivery
+func (p *Processor) publishScheduled(producer messageProducer) scheduler.Publisher {
+	return func(e scheduler.Entry, bundle *fhir.Bundle) error {
+		onDelivered := p.versionCommit(bundle, e.MessageKey)
+
+		if p.config.App.Mapper.Provenance {
+			if err := mapper.AddProvenance(bundle, e.Notification, mapper.Source{EvaluatedAt: &e.Due}); err != nil {
+				return err
+			}
+		}
+		if err := p.convertBundle(bundle); err != nil {
+			return err
+		}
+
+		domain := mapper.DomainOf(e.Notification)
+		ev := producer.SendBundle(p.outputTopic(domain), e.MessageKey, time.Now(), bundle, nil, make(chan os.Signal))
+		if err := deliveryError(ev); err != nil {
+			return err
+		}
+
+		if onDelivered != nil {
+			onDelivered()
+		}
+		return nil
+	}
+}
+
+// deadLetterScheduled sends the reduced notification of scheduler entries, which failed too often, to the
+// dead-letter topic, if configured, and waits for their delivery
+func (p *Processor) deadLetterScheduled(producer messageProducer) scheduler.DeadLetter {
+	return func(e scheduler.Entry, err error) error {
+		class := errorClass(err)
+		failedNotifications.Add(class, 1)
+
+		topic := p.config.Kafka.DeadLetterTopic
+		if topic == "" {
+			return nil
+		}
+
+		value, mErr := json.Marshal(e.Notification)
+		if mErr != nil {
+			return mErr
+		}
+		headers := []cKafka.Header{
+			{Key: "error", Value: []byte(err.Error())},
+			{Key: "error-class", Value: []byte(class)},
+			{Key: "scheduled-key", Value: []byte(e.Key)},
+			{Key: "scheduled-due", Value: []byte(e.Due.Format(time.RFC3339))},
+			{Key: "attempts", Value: []byte(strconv.Itoa(e.Attempts))},
+		}
+		return deliveryError(producer.Send(topic, e.MessageKey, time.Now(), value, headers, make(chan os.Signal)))
+	}
+}
+
+// deliveryError returns the error of the delivery event. Missing events are errors
+func deliveryError(e cKafka.Event) error {
+	switch ev := e.(type) {
+	case *cKafka.Message:
+		return ev.TopicPartition.Error
+	case cKafka.Error:
+		return ev
+	case *cKafka.Error:
+		return ev
+	default:
+		return errors.New("no delivery report received")
+	}
 }
 
 func syncConsumerCommits(c *ConsentConsumer) {
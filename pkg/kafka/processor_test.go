@@ -0,0 +1,482 @@
+package kafka
+
+import (
+	"consent-to-fhir/pkg/client"
+	"consent-to-fhir/pkg/config"
+	"consent-to-fhir/pkg/model"
+	"consent-to-fhir/pkg/scheduler"
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	cKafka "github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+type sent struct {
+	topic   string
+	value   []byte
+	bundle  *fhir.Bundle
+	headers []cKafka.Header
+}
+
+type TestProducer struct {
+	sent  []sent
+	event cKafka.Event
+}
+
+func (p *TestProducer) SendBundle(topic string, _ []byte, _ time.Time, bundle *fhir.Bundle, headers []cKafka.Header,
+	_ chan os.Signal) cKafka.Event {
+	p.sent = append(p.sent, sent{topic: topic, bundle: bundle, headers: headers})
+	return p.event
+}
+
+func (p *TestProducer) Send(topic string, _ []byte, _ time.Time, msg []byte, headers []cKafka.Header,
+	_ chan os.Signal) cKafka.Event {
+	p.sent = append(p.sent, sent{topic: topic, value: msg, headers: headers})
+	return p.event
+}
+
+type TestConsumer struct {
+	stored []*cKafka.Message
+	paused []time.Duration
+}
+
+func (c *TestConsumer) StoreOffset(msg *cKafka.Message) {
+	c.stored = append(c.stored, msg)
+}
+
+func (c *TestConsumer) Pause(_ *cKafka.Message, d time.Duration) {
+	c.paused = append(c.paused, d)
+}
+
+func createTestMessage(t *testing.T, n model.Notification) *cKafka.Message {
+	value, err := json.Marshal(n)
+	assert.NoError(t, err)
+
+	topic := "consent"
+	return &cKafka.Message{
+		TopicPartition: cKafka.TopicPartition{Topic: &topic, Partition: 1, Offset: 42},
+		Key:            []byte("key"),
+		Value:          value,
+	}
+}
+
+func createTestNotification(notificationType string, previous, current bool) model.Notification {
+	state := func(value bool) []model.PolicyState {
+		return []model.PolicyState{{
+			Key:   &model.PolicyStateKey{DomainName: of("MII"), Name: of("IDAT_erheben"), Version: of("1.0")},
+			Value: value,
+		}}
+	}
+
+	return model.Notification{
+		Type: notificationType,
+		ConsentKey: &model.ConsentKey{
+			ConsentTemplateKey: &model.ConsentTemplateKey{DomainName: of("MII")},
+			SignerIds:          []model.SignerId{{IdType: "Patienten-ID", Id: "42"}},
+		},
+		PreviousPolicyStates: state(previous),
+		CurrentPolicyStates:  state(current),
+	}
+}
+
+func of(s string) *string {
+	return &s
+}
+
+// count returns the current value of a metric map entry
+func count(m *expvar.Map, key string) int64 {
+	if v, ok := m.Get(key).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+// runProcessMessages processes the notification and returns whether the consumers have been stopped
+func runProcessMessages(p *Processor, producer *TestProducer, c *TestConsumer, msg *cKafka.Message) bool {
+	sigchan := make(chan os.Signal, 1)
+	p.processMessages(context.Background(), producer, c, msg, sigchan)
+
+	select {
+	case <-sigchan:
+		return true
+	default:
+		return false
+	}
+}
+
+// delivered creates a successful delivery report
+func delivered() cKafka.Event {
+	topic := "fhir"
+	return &cKafka.Message{TopicPartition: cKafka.TopicPartition{Topic: &topic}}
+}
+
+func TestProcessMessages_Dispatch(t *testing.T) {
+	var handled []string
+	handler := func(name string) NotificationHandler {
+		return func(_ context.Context, _ model.Notification) (*fhir.Bundle, error) {
+			handled = append(handled, name)
+			return &fhir.Bundle{Type: fhir.BundleTypeTransaction}, nil
+		}
+	}
+
+	p := &Processor{handlers: map[string]NotificationHandler{}}
+	p.RegisterHandler(model.Revoke, handler("revoke"))
+	p.RegisterHandler("", handler("legacy"))
+
+	cases := []struct {
+		name             string
+		notificationType string
+		expectedHandler  []string
+		expectedSkipped  int64
+	}{
+		{"registered", model.Revoke, []string{"revoke"}, 0},
+		{"legacy", "", []string{"legacy"}, 0},
+		{"notHandled", model.AddSignerIdToSignerId, nil, 1},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			handled = nil
+			producer, consumer := &TestProducer{event: delivered()}, &TestConsumer{}
+			skipped := count(skippedNotifications, c.notificationType)
+
+			stopped := runProcessMessages(p, producer, consumer,
+				createTestMessage(t, createTestNotification(c.notificationType, false, true)))
+
+			assert.False(t, stopped)
+			assert.Equal(t, c.expectedHandler, handled)
+			assert.Equal(t, c.expectedSkipped, count(skippedNotifications, c.notificationType)-skipped)
+			assert.Len(t, consumer.stored, 1)
+			if c.expectedHandler == nil {
+				assert.Empty(t, producer.sent)
+			} else {
+				assert.Len(t, producer.sent, 1)
+			}
+		})
+	}
+}
+
+func TestProcessMessages_Domain(t *testing.T) {
+	p := &Processor{
+		config: config.AppConfig{App: config.App{Mapper: config.Mapper{
+			RestrictDomains: true,
+			Domains:         map[string]config.Domain{"Other": {}},
+		}}},
+		handlers: map[string]NotificationHandler{
+			model.AddConsent: func(_ context.Context, _ model.Notification) (*fhir.Bundle, error) {
+				t.Fatal("handler called")
+				return nil, nil
+			},
+		},
+	}
+	producer, consumer := &TestProducer{}, &TestConsumer{}
+	skipped := count(domainNotifications, "MII")
+
+	runProcessMessages(p, producer, consumer, createTestMessage(t, createTestNotification(model.AddConsent, false, true)))
+
+	assert.Equal(t, int64(1), count(domainNotifications, "MII")-skipped)
+	assert.Len(t, consumer.stored, 1)
+	assert.Empty(t, producer.sent)
+}
+
+func TestProcessMessages_SkipUnchanged(t *testing.T) {
+	cases := []struct {
+		name              string
+		notificationType  string
+		context           *model.Context
+		previous, current bool
+		expectedSent      int
+		expectedUnchanged int64
+	}{
+		{"unchanged", model.AddConsent, nil, true, true, 0, 1},
+		{"changed", model.AddConsent, nil, false, true, 1, 0},
+		{"qcUpdate", model.UpdateConsentInUse, &model.Context{Qc: &model.Qc{QcPassed: true}}, true, true, 1, 0},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p := &Processor{
+				config: config.AppConfig{App: config.App{Mapper: config.Mapper{SkipUnchanged: true}}},
+				handlers: map[string]NotificationHandler{
+					c.notificationType: func(_ context.Context, _ model.Notification) (*fhir.Bundle, error) {
+						return &fhir.Bundle{Type: fhir.BundleTypeTransaction}, nil
+					},
+				},
+			}
+			producer, consumer := &TestProducer{}, &TestConsumer{}
+			unchanged := unchangedNotifications.Value()
+			n := createTestNotification(c.notificationType, c.previous, c.current)
+			n.Context = c.context
+
+			runProcessMessages(p, producer, consumer, createTestMessage(t, n))
+
+			assert.Len(t, producer.sent, c.expectedSent)
+			assert.Equal(t, c.expectedUnchanged, unchangedNotifications.Value()-unchanged)
+			if c.expectedSent > 0 && c.context == nil {
+				assert.Equal(t, "policy-changes", producer.sent[0].headers[0].Key)
+			}
+		})
+	}
+}
+
+func TestProcessMessages_Error(t *testing.T) {
+	cases := []struct {
+		name            string
+		err             error
+		deadLetterTopic string
+		expectedPaused  bool
+		expectedSent    string
+		expectedClass   string
+	}{
+		{
+			name:           "transient",
+			err:            &client.RequestError{Class: client.ErrTransient, Method: "POST", Url: "http://gics"},
+			expectedPaused: true,
+		},
+		{
+			name:           "unauthorized",
+			err:            &client.RequestError{Class: client.ErrUnauthorized, Method: "POST", Url: "http://gics"},
+			expectedPaused: true,
+		},
+		{
+			name:            "canceled",
+			err:             context.Canceled,
+			deadLetterTopic: "dlq",
+		},
+		{
+			name:            "deadLetter",
+			err:             &client.RequestError{Class: client.ErrRejected, Method: "POST", Url: "http://gics"},
+			deadLetterTopic: "dlq",
+			expectedSent:    "dlq",
+			expectedClass:   "rejected",
+		},
+		{
+			name:          "noDeadLetterTopic",
+			err:           errors.New("mapping failed"),
+			expectedClass: "mapping",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p := &Processor{
+				config: config.AppConfig{Kafka: config.Kafka{DeadLetterTopic: c.deadLetterTopic}},
+				handlers: map[string]NotificationHandler{
+					model.AddConsent: func(_ context.Context, _ model.Notification) (*fhir.Bundle, error) {
+						return nil, c.err
+					},
+				},
+			}
+			producer, consumer := &TestProducer{}, &TestConsumer{}
+			failed := count(failedNotifications, c.expectedClass)
+			msg := createTestMessage(t, createTestNotification(model.AddConsent, false, true))
+
+			runProcessMessages(p, producer, consumer, msg)
+
+			assert.Equal(t, c.expectedPaused, len(consumer.paused) == 1)
+			assert.Empty(t, consumer.stored)
+			if c.expectedClass != "" {
+				assert.Equal(t, int64(1), count(failedNotifications, c.expectedClass)-failed)
+			}
+			if c.expectedSent == "" {
+				assert.Empty(t, producer.sent)
+				return
+			}
+
+			assert.Len(t, producer.sent, 1)
+			assert.Equal(t, c.expectedSent, producer.sent[0].topic)
+			assert.Equal(t, msg.Value, producer.sent[0].value)
+			assert.Contains(t, producer.sent[0].headers, cKafka.Header{Key: "error-class", Value: []byte(c.expectedClass)})
+			assert.Contains(t, producer.sent[0].headers, cKafka.Header{Key: "source-offset", Value: []byte("42")})
+		})
+	}
+}
+
+func TestHandleDelivery(t *testing.T) {
+	topic := "fhir"
+	cases := []struct {
+		name              string
+		event             cKafka.Event
+		expectedDelivered bool
+		expectedStopped   bool
+	}{
+		{"delivered", delivered(), true, false},
+		{"failed", &cKafka.Message{TopicPartition: cKafka.TopicPartition{Topic: &topic,
+			Error: cKafka.NewError(cKafka.ErrMsgTimedOut, "timed out", false)}}, false, true},
+		{"error", cKafka.NewError(cKafka.ErrAllBrokersDown, "brokers down", false), false, true},
+		{"shutdown", nil, false, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			consumer := &TestConsumer{}
+			sigchan := make(chan os.Signal, 1)
+			msg := createTestMessage(t, createTestNotification(model.AddConsent, false, true))
+			var called bool
+
+			handleDelivery(sigchan, consumer, msg, c.event, func() { called = true })
+
+			assert.Equal(t, c.expectedDelivered, called)
+			assert.Equal(t, c.expectedDelivered, len(consumer.stored) == 1)
+			assert.Equal(t, c.expectedStopped, len(sigchan) == 1)
+		})
+	}
+}
+
+func TestProcessMessages_Delivery(t *testing.T) {
+	topic := "fhir"
+	cases := []struct {
+		name           string
+		event          cKafka.Event
+		expectedStored bool
+	}{
+		{"delivered", delivered(), true},
+		{"failed", &cKafka.Message{TopicPartition: cKafka.TopicPartition{Topic: &topic,
+			Error: cKafka.NewError(cKafka.ErrMsgTimedOut, "timed out", false)}}, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p := &Processor{handlers: map[string]NotificationHandler{
+				model.AddConsent: func(_ context.Context, _ model.Notification) (*fhir.Bundle, error) {
+					return &fhir.Bundle{Type: fhir.BundleTypeTransaction}, nil
+				},
+			}}
+			producer, consumer := &TestProducer{event: c.event}, &TestConsumer{}
+
+			stopped := runProcessMessages(p, producer, consumer,
+				createTestMessage(t, createTestNotification(model.AddConsent, false, true)))
+
+			assert.Equal(t, c.expectedStored, len(consumer.stored) == 1)
+			assert.Equal(t, !c.expectedStored, stopped)
+		})
+	}
+}
+
+func TestPublishScheduled(t *testing.T) {
+	topic := "fhir"
+	cases := []struct {
+		name     string
+		event    cKafka.Event
+		expected bool
+	}{
+		{"delivered", &cKafka.Message{TopicPartition: cKafka.TopicPartition{Topic: &topic}}, true},
+		{"failed", &cKafka.Message{TopicPartition: cKafka.TopicPartition{Topic: &topic,
+			Error: cKafka.NewError(cKafka.ErrMsgTimedOut, "timed out", false)}}, false},
+		{"error", cKafka.NewError(cKafka.ErrAllBrokersDown, "brokers down", false), false},
+		{"noReport", nil, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p := &Processor{}
+			producer := &TestProducer{event: c.event}
+
+			err := p.publishScheduled(producer)(scheduler.Entry{MessageKey: []byte("key")},
+				&fhir.Bundle{Type: fhir.BundleTypeTransaction})
+
+			assert.Equal(t, c.expected, err == nil)
+			assert.Len(t, producer.sent, 1)
+		})
+	}
+}
+
+func TestPublishScheduled_Provenance(t *testing.T) {
+	consent, _ := fhir.Consent{}.MarshalJSON()
+	p := &Processor{config: config.AppConfig{App: config.App{Mapper: config.Mapper{Provenance: true}}}}
+	producer := &TestProducer{event: delivered()}
+	due := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	err := p.publishScheduled(producer)(scheduler.Entry{Due: due, MessageKey: []byte("key")},
+		&fhir.Bundle{Type: fhir.BundleTypeTransaction, Entry: []fhir.BundleEntry{{
+			Resource: consent,
+			Request:  &fhir.BundleEntryRequest{Method: fhir.HTTPVerbPUT, Url: "Consent/42"},
+		}}})
+
+	assert.NoError(t, err)
+	bundle := producer.sent[0].bundle
+	assert.Len(t, bundle.Entry, 2)
+	provenance, _ := fhir.UnmarshalProvenance(bundle.Entry[1].Resource)
+	assert.Equal(t, "Consent/42", *provenance.Target[0].Reference)
+	assert.Equal(t, "Expiry re-evaluation at 2025-03-01T00:00:00Z", *provenance.Entity[1].What.Display)
+}
+
+func TestProcessMessages_ScheduleAfterDelivery(t *testing.T) {
+	topic := "fhir"
+	end := time.Now().AddDate(1, 0, 0).Format(time.RFC3339)
+	consent, _ := fhir.Consent{Provision: &fhir.ConsentProvision{
+		Provision: []fhir.ConsentProvision{{Period: &fhir.Period{End: &end}}},
+	}}.MarshalJSON()
+
+	cases := []struct {
+		name           string
+		event          cKafka.Event
+		expectedQueued int
+	}{
+		{"delivered", delivered(), 1},
+		{"failed", &cKafka.Message{TopicPartition: cKafka.TopicPartition{Topic: &topic,
+			Error: cKafka.NewError(cKafka.ErrMsgTimedOut, "timed out", false)}}, 0},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			queue, _ := scheduler.NewFileQueue("")
+			p := &Processor{
+				scheduler: scheduler.NewScheduler(queue, nil, nil, 0),
+				handlers: map[string]NotificationHandler{
+					model.AddConsent: func(_ context.Context, _ model.Notification) (*fhir.Bundle, error) {
+						return &fhir.Bundle{Type: fhir.BundleTypeTransaction, Entry: []fhir.BundleEntry{{
+							Resource: consent,
+							Request:  &fhir.BundleEntryRequest{Method: fhir.HTTPVerbPUT, Url: "Consent"},
+						}}}, nil
+					},
+				},
+			}
+
+			runProcessMessages(p, &TestProducer{event: c.event}, &TestConsumer{},
+				createTestMessage(t, createTestNotification(model.AddConsent, false, true)))
+
+			assert.Equal(t, c.expectedQueued, queue.Len())
+		})
+	}
+}
+
+func TestDeadLetterScheduled(t *testing.T) {
+	cases := []struct {
+		name            string
+		deadLetterTopic string
+		expectedSent    int
+	}{
+		{"sent", "dlq", 1},
+		{"noDeadLetterTopic", "", 0},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p := &Processor{config: config.AppConfig{Kafka: config.Kafka{DeadLetterTopic: c.deadLetterTopic}}}
+			producer := &TestProducer{event: delivered()}
+			e := scheduler.Entry{
+				Key:          "MII|Patienten-ID=42",
+				MessageKey:   []byte("key"),
+				Notification: createTestNotification(model.AddConsent, false, true),
+				Attempts:     10,
+			}
+
+			err := p.deadLetterScheduled(producer)(e, &client.RequestError{Class: client.ErrTransient, Url: "http://gics"})
+
+			assert.NoError(t, err)
+			assert.Len(t, producer.sent, c.expectedSent)
+			if c.expectedSent > 0 {
+				assert.Equal(t, "dlq", producer.sent[0].topic)
+				assert.Contains(t, producer.sent[0].headers, cKafka.Header{Key: "error-class", Value: []byte("transient")})
+				assert.Contains(t, producer.sent[0].headers, cKafka.Header{Key: "attempts", Value: []byte("10")})
+			}
+		})
+	}
+}
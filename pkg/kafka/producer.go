@@ -2,6 +2,7 @@ package kafka
 
 import (
 	"consent-to-fhir/pkg/config"
+	"consent-to-fhir/pkg/mapper"
 	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
 	"github.com/samply/golang-fhir-models/fhir-models/fhir"
 	log "github.com/sirupsen/logrus"
@@ -12,9 +13,16 @@ import (
 type FhirProducer struct {
 	Producer *kafka.Producer
 	Topic    string
+	Encoding string
 }
 
 func NewProducer(config config.Kafka) *FhirProducer {
+	switch config.OutputEncoding {
+	case "", mapper.EncodingJson, mapper.EncodingJsonPretty, mapper.EncodingXml:
+	default:
+		log.WithField("encoding", config.OutputEncoding).Error("Unsupported output encoding. Terminating")
+		os.Exit(1)
+	}
 
 	p, err := kafka.NewProducer(&kafka.ConfigMap{
 		"bootstrap.servers":        config.BootstrapServers,
@@ -32,43 +40,57 @@ func NewProducer(config config.Kafka) *FhirProducer {
 	return &FhirProducer{
 		Producer: p,
 		Topic:    config.OutputTopic,
+		Encoding: config.OutputEncoding,
 	}
 }
 
-func (p *FhirProducer) SendBundle(key []byte, timestamp time.Time, bundle *fhir.Bundle, deliveryChan chan kafka.Event, sigchan chan os.Signal) {
-	if bundle != nil {
-		byteVal, err := bundle.MarshalJSON()
-		if err != nil {
-			log.WithError(err).Error("Failed to serialize Bundle to JSON")
-			// TODO
-			deliveryChan <- kafka.Error{}
-			return
-		}
-		p.Send(key, timestamp, byteVal, deliveryChan, sigchan)
+// SendBundle produces the encoded bundle and returns its delivery event. Returns nil on shutdown
+func (p *FhirProducer) SendBundle(topic string, key []byte, timestamp time.Time, bundle *fhir.Bundle,
+	headers []kafka.Header, sigchan chan os.Signal) kafka.Event {
+	if bundle == nil {
+		return nil
 	}
+
+	byteVal, contentType, err := mapper.EncodeBundle(bundle, p.Encoding)
+	if err != nil {
+		log.WithError(err).WithField("encoding", p.Encoding).Error("Failed to serialize Bundle")
+		return kafka.Error{}
+	}
+	headers = append(headers, kafka.Header{Key: "content-type", Value: []byte(contentType)})
+	return p.Send(topic, key, timestamp, byteVal, headers, sigchan)
 }
 
-func (p *FhirProducer) Send(key []byte, timestamp time.Time, msg []byte, deliveryChan chan kafka.Event, sigchan chan os.Signal) {
+// Send produces the message to the topic or the default output topic, if empty, and waits for its delivery
+// event. The event is received by the caller only, so it decides whether the offset is stored. Returns nil on
+// shutdown
+func (p *FhirProducer) Send(topic string, key []byte, timestamp time.Time, msg []byte, headers []kafka.Header,
+	sigchan chan os.Signal) kafka.Event {
+	if topic == "" {
+		topic = p.Topic
+	}
 
+	deliveryChan := make(chan kafka.Event, 1)
 	err := p.Producer.Produce(&kafka.Message{
-		TopicPartition: kafka.TopicPartition{Topic: &p.Topic, Partition: kafka.PartitionAny},
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
 		Key:            key,
 		Timestamp:      timestamp,
 		Value:          msg,
+		Headers:        headers,
 	}, deliveryChan)
 	if err != nil {
 		if err.(kafka.Error).Code() == kafka.ErrQueueFull {
 			// Producer queue is full, wait 1s for messages
 			// to be delivered then try again.
 			time.Sleep(time.Second)
-			p.Send(key, timestamp, msg, deliveryChan, sigchan)
+			return p.Send(topic, key, timestamp, msg, headers, sigchan)
 		}
+		return err.(kafka.Error)
 	}
 
 	select {
 	case <-sigchan:
-		return
-	case <-deliveryChan:
-		return
+		return nil
+	case e := <-deliveryChan:
+		return e
 	}
 }
@@ -0,0 +1,149 @@
+package mapper
+
+import (
+	"consent-to-fhir/pkg/config"
+	"encoding/json"
+	"fmt"
+	"github.com/knadh/koanf/parsers/yaml"
+	"github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// PolicyCodeMap maps gICS policy codes to target codings as defined by FHIR ConceptMap resources
+type PolicyCodeMap struct {
+	maps []policyConceptMap
+}
+
+type policyConceptMap struct {
+	domain  string
+	profile string
+	groups  []fhir.ConceptMapGroup
+}
+
+// LoadConceptMaps reads ConceptMap resources from FHIR json or yaml files
+func LoadConceptMaps(sources []config.ConceptMap) (*PolicyCodeMap, error) {
+	codeMap := &PolicyCodeMap{}
+
+	for _, s := range sources {
+		cm, err := readConceptMap(s.File)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load ConceptMap from '%s': %w", s.File, err)
+		}
+
+		codeMap.maps = append(codeMap.maps, policyConceptMap{
+			domain:  s.Domain,
+			profile: s.Profile,
+			groups:  cm.Group,
+		})
+	}
+
+	return codeMap, nil
+}
+
+func readConceptMap(path string) (*fhir.ConceptMap, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yml", ".yaml":
+		// convert to json
+		values, err := yaml.Parser().Unmarshal(data)
+		if err != nil {
+			return nil, err
+		}
+		if data, err = json.Marshal(values); err != nil {
+			return nil, err
+		}
+	}
+
+	cm, err := fhir.UnmarshalConceptMap(data)
+	if err != nil {
+		return nil, err
+	}
+	return &cm, nil
+}
+
+// Applies checks if any ConceptMap is configured for the domain and profile
+func (p *PolicyCodeMap) Applies(domain, profile string) bool {
+	return len(p.matching(domain, profile)) > 0
+}
+
+// Map returns the target coding of the first mapped source coding
+func (p *PolicyCodeMap) Map(domain, profile string, codes []fhir.CodeableConcept) (*fhir.Coding, bool) {
+	for _, cm := range p.matching(domain, profile) {
+		for _, code := range codes {
+			for _, coding := range code.Coding {
+				if target := cm.mapCoding(coding); target != nil {
+					return target, true
+				}
+			}
+		}
+	}
+
+	return nil, false
+}
+
+func (p *PolicyCodeMap) matching(domain, profile string) []policyConceptMap {
+	if p == nil {
+		return nil
+	}
+
+	var maps []policyConceptMap
+	for _, cm := range p.maps {
+		if (cm.domain == "" || cm.domain == domain) && (cm.profile == "" || cm.profile == profile) {
+			maps = append(maps, cm)
+		}
+	}
+	return maps
+}
+
+func (cm policyConceptMap) mapCoding(coding fhir.Coding) *fhir.Coding {
+	if coding.System == nil || coding.Code == nil {
+		return nil
+	}
+
+	for _, g := range cm.groups {
+		if g.Source == nil || *g.Source != *coding.System {
+			continue
+		}
+
+		for _, e := range g.Element {
+			if e.Code == nil || *e.Code != *coding.Code {
+				continue
+			}
+
+			for _, t := range e.Target {
+				if t.Code == nil || t.Equivalence == fhir.ConceptMapEquivalenceUnmatched ||
+					t.Equivalence == fhir.ConceptMapEquivalenceDisjoint {
+					continue
+				}
+				return &fhir.Coding{System: g.Target, Version: g.TargetVersion, Code: t.Code, Display: t.Display}
+			}
+		}
+	}
+
+	return nil
+}
+
+// policyCode returns the gICS policy code (or any other code) for reporting purposes
+func policyCode(codes []fhir.CodeableConcept) string {
+	code := ""
+	for _, cc := range codes {
+		for _, coding := range cc.Coding {
+			if coding.Code == nil {
+				continue
+			}
+			if coding.System != nil && *coding.System == gicsPolicySystem {
+				return *coding.Code
+			}
+			if code == "" {
+				code = *coding.Code
+			}
+		}
+	}
+	return code
+}
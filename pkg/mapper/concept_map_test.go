@@ -0,0 +1,125 @@
+package mapper
+
+import (
+	"consent-to-fhir/pkg/config"
+	"context"
+	"github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestLoadConceptMaps(t *testing.T) {
+	m, err := LoadConceptMaps([]config.ConceptMap{
+		{File: "testdata/policy-conceptmap.json", Domain: "MII", Profile: MiiProfile},
+		{File: "testdata/policy-conceptmap.yml", Domain: "Test"},
+	})
+
+	assert.NoError(t, err)
+	assert.True(t, m.Applies("MII", MiiProfile))
+	assert.False(t, m.Applies("MII", consentManagementProfile))
+	assert.True(t, m.Applies("Test", consentManagementProfile))
+	assert.False(t, m.Applies("Other", ""))
+}
+
+func TestLoadConceptMaps_InvalidFile(t *testing.T) {
+	_, err := LoadConceptMaps([]config.ConceptMap{{File: "testdata/missing.json"}})
+
+	assert.Error(t, err)
+}
+
+func TestPolicyCodeMap_Map(t *testing.T) {
+	m, _ := LoadConceptMaps([]config.ConceptMap{
+		{File: "testdata/policy-conceptmap.json", Domain: "MII"},
+		{File: "testdata/policy-conceptmap.yml", Domain: "Test"},
+	})
+
+	cases := []struct {
+		name     string
+		domain   string
+		code     string
+		expected *fhir.Coding
+	}{
+		{
+			name:   "json",
+			domain: "MII",
+			code:   "IDAT_erheben",
+			expected: &fhir.Coding{
+				System:  Of("urn:oid:2.16.840.1.113883.3.1937.777.24.5.3"),
+				Code:    Of("2.16.840.1.113883.3.1937.777.24.5.3.2"),
+				Display: Of("IDAT_erheben"),
+			},
+		},
+		{
+			name:   "yaml",
+			domain: "Test",
+			code:   "IDAT_erheben",
+			expected: &fhir.Coding{
+				System: Of("https://fhir.diz.uni-marburg.de/fhir/CodeSystem/test-policy"),
+				Code:   Of("test-idat"),
+			},
+		},
+		{
+			name:     "unmatched",
+			domain:   "MII",
+			code:     "Rekontaktierung_Zusatzbefund",
+			expected: nil,
+		},
+		{
+			name:     "missing",
+			domain:   "Test",
+			code:     "MDAT_erheben",
+			expected: nil,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			codes := []fhir.CodeableConcept{{Coding: []fhir.Coding{
+				{System: Of("https://ths-greifswald.de/fhir/CodeSystem/gics/Policy"), Code: Of(c.code)},
+			}}}
+
+			actual, ok := m.Map(c.domain, "", codes)
+
+			assert.Equal(t, c.expected != nil, ok)
+			assert.Equal(t, c.expected, actual)
+		})
+	}
+}
+
+func TestProcess_ConceptMap(t *testing.T) {
+	m := createTestMapper()
+	m.CodeMap, _ = LoadConceptMaps([]config.ConceptMap{{File: "testdata/policy-conceptmap.json"}})
+	m.Client = &TestGicsClient{
+		respFilePath: "testdata/current-policies-response.json",
+	}
+	input := []byte(`
+		{
+		  "consentKey": {
+			"consentTemplateKey": {
+			  "domainName": "MII",
+			  "name": "Patienteneinwilligung MII",
+			  "version": "1.6.d"
+			},
+			"signerIds": [
+			  {
+				"idType": "Patienten-ID",
+				"id": "42",
+				"orderNumber": 0
+			  }
+			],
+			"consentDate": "2023-05-02 01:57:27"
+		  }
+		}
+	`)
+
+	bundle := m.Process(context.Background(), input)
+	actual, _ := fhir.UnmarshalConsent(bundle.Entry[0].Resource)
+
+	var codes []string
+	for _, p := range actual.Provision.Provision {
+		codes = append(codes, *p.Code[0].Coding[0].Code)
+	}
+
+	// only mapped codes remain
+	assert.ElementsMatch(t, []string{"2.16.840.1.113883.3.1937.777.24.5.3.2", "2.16.840.1.113883.3.1937.777.24.5.3.6"}, codes)
+}
@@ -0,0 +1,135 @@
+package mapper
+
+import (
+	"consent-to-fhir/pkg/config"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"strings"
+	"time"
+)
+
+const (
+	IdStrategyLegacy = "legacy"
+	IdStrategySha256 = "sha256"
+	IdStrategyHmac   = "hmac"
+	IdStrategyUuid5  = "uuid5"
+)
+
+// validateConsentId checks the consent id strategy configuration
+func validateConsentId(c config.ConsentId) error {
+	for _, s := range []string{c.Strategy, c.MigrateFrom} {
+		switch s {
+		case "", IdStrategyLegacy, IdStrategySha256:
+		case IdStrategyHmac:
+			if c.Secret == "" {
+				return errors.New("consent id strategy 'hmac' requires a secret")
+			}
+		case IdStrategyUuid5:
+			if _, err := parseUuid(c.Namespace); err != nil {
+				return fmt.Errorf("consent id strategy 'uuid5' requires a valid namespace: %w", err)
+			}
+		default:
+			return fmt.Errorf("unknown consent id strategy '%s'", s)
+		}
+	}
+	if c.MigrateUntil != "" {
+		if _, err := time.Parse(time.DateOnly, c.MigrateUntil); err != nil {
+			return fmt.Errorf("invalid consent id migration date: %w", err)
+		}
+	}
+	return nil
+}
+
+// consentId creates the consent id of the domain and signer id with the configured strategy
+func (m *GicsMapper) consentId(domain, signerId string) string {
+	return createConsentId(m.Config.ConsentId.Strategy, m.Config.ConsentId, domain, signerId)
+}
+
+// previousConsentId returns the consent id created with the strategy to migrate from, if it differs from the
+// current consent id and the migration window is open
+func (m *GicsMapper) previousConsentId(domain, signerId string, now time.Time) (string, bool) {
+	c := m.Config.ConsentId
+	if c.MigrateFrom == "" {
+		return "", false
+	}
+	if c.MigrateUntil != "" {
+		until, err := time.Parse(time.DateOnly, c.MigrateUntil)
+		if err == nil && !now.Before(until.AddDate(0, 0, 1)) {
+			return "", false
+		}
+	}
+
+	id := createConsentId(c.MigrateFrom, c, domain, signerId)
+	return id, id != m.consentId(domain, signerId)
+}
+
+// migrationEntry creates a delete request for the consent's previous identifier during migration
+func (m *GicsMapper) migrationEntry(domain, signerId string) *fhir.BundleEntry {
+	id, ok := m.previousConsentId(domain, signerId, time.Now())
+	if !ok {
+		return nil
+	}
+
+	return &fhir.BundleEntry{
+		Request: &fhir.BundleEntryRequest{
+			Method: fhir.HTTPVerbDELETE,
+			Url:    fmt.Sprintf("Consent?identifier=%s|%s", *consentSystemOf(m.Config, domain), id),
+		},
+	}
+}
+
+func createConsentId(strategy string, c config.ConsentId, values ...string) string {
+	switch strategy {
+	case IdStrategySha256:
+		sum := sha256.Sum256(encodeValues(values...))
+		return hex.EncodeToString(sum[:])
+	case IdStrategyHmac:
+		h := hmac.New(sha256.New, []byte(c.Secret))
+		h.Write(encodeValues(values...))
+		return hex.EncodeToString(h.Sum(nil))
+	case IdStrategyUuid5:
+		ns, _ := parseUuid(c.Namespace)
+		return uuid5(ns, encodeValues(values...))
+	default:
+		return hash(values...)
+	}
+}
+
+// encodeValues prefixes each value with its length, to prevent collisions of concatenated values
+func encodeValues(values ...string) []byte {
+	var b []byte
+	for _, v := range values {
+		b = binary.BigEndian.AppendUint32(b, uint32(len(v)))
+		b = append(b, v...)
+	}
+	return b
+}
+
+// uuid5 creates a name-based UUID (RFC 9562 version 5) in the given namespace
+func uuid5(namespace []byte, name []byte) string {
+	h := sha1.New()
+	h.Write(namespace)
+	h.Write(name)
+	u := h.Sum(nil)[:16]
+	u[6] = (u[6] & 0x0f) | 0x50
+	u[8] = (u[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+}
+
+func parseUuid(s string) ([]byte, error) {
+	b, err := hex.DecodeString(strings.ReplaceAll(s, "-", ""))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != 16 {
+		return nil, fmt.Errorf("invalid UUID '%s'", s)
+	}
+	return b, nil
+}
@@ -0,0 +1,168 @@
+package mapper
+
+import (
+	"consent-to-fhir/pkg/config"
+	"context"
+	"fmt"
+	"github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+const testNamespace = "6ba7b811-9dad-11d1-80b4-00c04fd430c8"
+
+func TestCreateConsentId(t *testing.T) {
+	cases := []struct {
+		name     string
+		config   config.ConsentId
+		collides bool
+	}{
+		{
+			name:     "legacy",
+			config:   config.ConsentId{},
+			collides: true,
+		},
+		{
+			name:   "sha256",
+			config: config.ConsentId{Strategy: IdStrategySha256},
+		},
+		{
+			name:   "hmac",
+			config: config.ConsentId{Strategy: IdStrategyHmac, Secret: "secret"},
+		},
+		{
+			name:   "uuid5",
+			config: config.ConsentId{Strategy: IdStrategyUuid5, Namespace: testNamespace},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			id := createConsentId(c.config.Strategy, c.config, "MII1", "23")
+
+			assert.Equal(t, id, createConsentId(c.config.Strategy, c.config, "MII1", "23"))
+			assert.Equal(t, c.collides, id == createConsentId(c.config.Strategy, c.config, "MII", "123"))
+		})
+	}
+}
+
+func TestCreateConsentId_Hmac(t *testing.T) {
+	c1 := config.ConsentId{Strategy: IdStrategyHmac, Secret: "secret"}
+	c2 := config.ConsentId{Strategy: IdStrategyHmac, Secret: "other"}
+
+	assert.NotEqual(t, createConsentId(c1.Strategy, c1, "MII", "42"), createConsentId(c2.Strategy, c2, "MII", "42"))
+}
+
+func TestUuid5(t *testing.T) {
+	// RFC 9562 example: DNS namespace, "www.example.com"
+	ns, _ := parseUuid("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+
+	assert.Equal(t, "2ed6657d-e927-568b-95e1-2665a8aea6a2", uuid5(ns, []byte("www.example.com")))
+}
+
+func TestValidateConsentId(t *testing.T) {
+	cases := []struct {
+		name   string
+		config config.ConsentId
+		valid  bool
+	}{
+		{name: "default", config: config.ConsentId{}, valid: true},
+		{name: "hmac", config: config.ConsentId{Strategy: IdStrategyHmac, Secret: "secret"}, valid: true},
+		{name: "hmac without secret", config: config.ConsentId{Strategy: IdStrategyHmac}, valid: false},
+		{name: "uuid5", config: config.ConsentId{Strategy: IdStrategyUuid5, Namespace: testNamespace}, valid: true},
+		{name: "uuid5 invalid namespace", config: config.ConsentId{Strategy: IdStrategyUuid5, Namespace: "MII"}, valid: false},
+		{name: "unknown", config: config.ConsentId{Strategy: "md5"}, valid: false},
+		{name: "unknown migration", config: config.ConsentId{MigrateFrom: "md5"}, valid: false},
+		{name: "invalid migration date", config: config.ConsentId{MigrateFrom: IdStrategyLegacy, MigrateUntil: "01.01.2025"}, valid: false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := validateConsentId(c.config)
+
+			assert.Equal(t, c.valid, err == nil)
+		})
+	}
+}
+
+func TestPreviousConsentId(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name     string
+		config   config.ConsentId
+		expected bool
+	}{
+		{
+			name:     "no migration",
+			config:   config.ConsentId{Strategy: IdStrategySha256},
+			expected: false,
+		},
+		{
+			name:     "migration",
+			config:   config.ConsentId{Strategy: IdStrategySha256, MigrateFrom: IdStrategyLegacy},
+			expected: true,
+		},
+		{
+			name:     "open window",
+			config:   config.ConsentId{Strategy: IdStrategySha256, MigrateFrom: IdStrategyLegacy, MigrateUntil: "2024-06-01"},
+			expected: true,
+		},
+		{
+			name:     "closed window",
+			config:   config.ConsentId{Strategy: IdStrategySha256, MigrateFrom: IdStrategyLegacy, MigrateUntil: "2024-05-31"},
+			expected: false,
+		},
+		{
+			name:     "same strategy",
+			config:   config.ConsentId{MigrateFrom: IdStrategyLegacy},
+			expected: false,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			m := &GicsMapper{Config: config.Mapper{ConsentId: c.config}}
+
+			id, ok := m.previousConsentId("MII", "42", now)
+
+			assert.Equal(t, c.expected, ok)
+			if c.expected {
+				assert.Equal(t, hash("MII", "42"), id)
+			}
+		})
+	}
+}
+
+func TestProcess_MigrateConsentId(t *testing.T) {
+	m := createTestMapper()
+	m.Config.ConsentId = config.ConsentId{Strategy: IdStrategySha256, MigrateFrom: IdStrategyLegacy}
+	m.Client = &TestGicsClient{
+		respFilePath: "testdata/current-policies-response.json",
+	}
+
+	bundle, _ := m.Map(context.Background(), createTestNotification())
+	consent, _ := fhir.UnmarshalConsent(bundle.Entry[0].Resource)
+
+	assert.Equal(t, createConsentId(IdStrategySha256, m.Config.ConsentId, "MII", "42"), *consent.Id)
+	assert.Equal(t, fhir.HTTPVerbPUT, bundle.Entry[0].Request.Method)
+	assert.Equal(t, &fhir.BundleEntryRequest{
+		Method: fhir.HTTPVerbDELETE,
+		Url:    fmt.Sprintf("Consent?identifier=%s|%s", *m.Config.ConsentSystem, hash("MII", "42")),
+	}, bundle.Entry[2].Request)
+}
+
+func TestProcess_MigrateConsentId_Delete(t *testing.T) {
+	m := createTestMapper()
+	m.Config.ConsentId = config.ConsentId{Strategy: IdStrategySha256, MigrateFrom: IdStrategyLegacy}
+	m.Client = &TestGicsClient{
+		respFilePath: "testdata/empty-policies-response.json",
+	}
+
+	bundle, _ := m.Map(context.Background(), createTestNotification())
+
+	assert.Len(t, bundle.Entry, 2)
+	assert.Equal(t, fhir.HTTPVerbDELETE, bundle.Entry[0].Request.Method)
+	assert.Equal(t, fhir.HTTPVerbDELETE, bundle.Entry[1].Request.Method)
+}
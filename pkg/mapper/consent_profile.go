@@ -1,40 +1,76 @@
 package mapper
 
 import (
+	"consent-to-fhir/pkg/model"
 	"github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"sync"
 )
 
-const (
-	MiiProfile = "https://www.medizininformatik-initiative.de/fhir/modul-consent/StructureDefinition/mii-pr-consent-einwilligung"
+// ProfileMapper maps profile specific data of a Consent resource. The key of the signed consent template
+// may be nil, if unknown
+type ProfileMapper interface {
+	// Category returns the profile's consent categories
+	Category(consent fhir.Consent, template *model.ConsentTemplateKey) []fhir.CodeableConcept
+	// Policy returns the profile's consent policies
+	Policy(consent fhir.Consent, template *model.ConsentTemplateKey) []fhir.ConsentPolicy
+	// Provision rewrites the consent provision
+	Provision(provision *fhir.ConsentProvision, template *model.ConsentTemplateKey) *fhir.ConsentProvision
+	// Extension rewrites the consent extensions
+	Extension(extensions []fhir.Extension, template *model.ConsentTemplateKey) []fhir.Extension
+}
+
+// TemplateChecker is implemented by profile mappers, which only support known consent templates
+type TemplateChecker interface {
+	// Check returns an error, if the consent template is not supported
+	Check(template *model.ConsentTemplateKey) error
+}
+
+var (
+	profileMappers = map[string]ProfileMapper{}
+	profileLock    sync.RWMutex
 )
 
-func MapProfile(consent fhir.Consent) fhir.Consent {
+func init() {
+	RegisterProfileMapper(MiiProfile, NewMiiProfileMapper(nil, false))
+}
+
+// RegisterProfileMapper adds a ProfileMapper to the registry for the profile url,
+// replacing any existing one
+func RegisterProfileMapper(profile string, m ProfileMapper) {
+	profileLock.Lock()
+	defer profileLock.Unlock()
+
+	profileMappers[profile] = m
+}
+
+// GetProfileMapper returns the registered ProfileMapper for the profile url
+func GetProfileMapper(profile string) (ProfileMapper, bool) {
+	profileLock.RLock()
+	defer profileLock.RUnlock()
+
+	m, ok := profileMappers[profile]
+	return m, ok
+}
+
+func MapProfile(consent fhir.Consent, template *model.ConsentTemplateKey) fhir.Consent {
 	if consent.Meta == nil || len(consent.Meta.Profile) == 0 {
 		return consent
 	}
 
-	p := consent.Meta.Profile[0]
-
-	// only mii profile, currently
-	if p == MiiProfile {
-		consent.Category = []fhir.CodeableConcept{
-			{
-				Coding: []fhir.Coding{{System: Of("http://loinc.org"), Code: Of("57016-8")}},
-			},
-			{
-				Coding: []fhir.Coding{{
-					System: Of("https://www.medizininformatik-initiative.de/fhir/modul-consent/CodeSystem/mii-cs-consent-consent_category"),
-					Code:   Of("2.16.840.1.113883.3.1937.777.24.2.184")}},
-			},
-		}
-		consent.Policy = []fhir.ConsentPolicy{
-			{
-				// Patienteneinwilligung MII|1.6.d
-				Uri: Of("urn:oid:2.16.840.1.113883.3.1937.777.24.2.1790"),
-			},
-		}
+	m, ok := GetProfileMapper(consent.Meta.Profile[0])
+	if !ok {
+		return consent
 	}
 
+	return mapProfile(m, consent, template)
+}
+
+func mapProfile(m ProfileMapper, consent fhir.Consent, template *model.ConsentTemplateKey) fhir.Consent {
+	consent.Category = m.Category(consent, template)
+	consent.Policy = m.Policy(consent, template)
+	consent.Provision = m.Provision(consent.Provision, template)
+	consent.Extension = m.Extension(consent.Extension, template)
+
 	return consent
 }
 
@@ -1,6 +1,7 @@
 package mapper
 
 import (
+	"consent-to-fhir/pkg/model"
 	"github.com/samply/golang-fhir-models/fhir-models/fhir"
 	"github.com/stretchr/testify/assert"
 	"testing"
@@ -45,8 +46,42 @@ func TestGetPolicy(t *testing.T) {
 
 func runMapProfile(t *testing.T, expected TestCase) {
 	c := fhir.Consent{Meta: &fhir.Meta{Profile: []string{expected.profile}}}
-	actual := MapProfile(c)
+	actual := MapProfile(c, nil)
 
 	assert.Equal(t, expected.category, actual.Category)
 	assert.Equal(t, expected.policy, actual.Policy)
 }
+
+type TestProfileMapper struct{}
+
+func (m *TestProfileMapper) Category(_ fhir.Consent, _ *model.ConsentTemplateKey) []fhir.CodeableConcept {
+	return []fhir.CodeableConcept{{Coding: []fhir.Coding{{System: Of("http://loinc.org"), Code: Of("59284-0")}}}}
+}
+
+func (m *TestProfileMapper) Policy(c fhir.Consent, _ *model.ConsentTemplateKey) []fhir.ConsentPolicy {
+	return c.Policy
+}
+
+func (m *TestProfileMapper) Provision(p *fhir.ConsentProvision, _ *model.ConsentTemplateKey) *fhir.ConsentProvision {
+	p.Type = Of(fhir.ConsentProvisionTypePermit)
+	return p
+}
+
+func (m *TestProfileMapper) Extension(_ []fhir.Extension, _ *model.ConsentTemplateKey) []fhir.Extension {
+	return []fhir.Extension{{Url: "https://fhir.local/StructureDefinition/test", ValueString: Of("test")}}
+}
+
+func TestRegisterProfileMapper(t *testing.T) {
+	profile := "https://fhir.local/StructureDefinition/test-consent"
+	RegisterProfileMapper(profile, &TestProfileMapper{})
+
+	c := fhir.Consent{
+		Meta:      &fhir.Meta{Profile: []string{profile}},
+		Provision: &fhir.ConsentProvision{Type: Of(fhir.ConsentProvisionTypeDeny)},
+	}
+	actual := MapProfile(c, nil)
+
+	assert.Equal(t, "59284-0", *actual.Category[0].Coding[0].Code)
+	assert.Equal(t, fhir.ConsentProvisionTypePermit, *actual.Provision.Type)
+	assert.Equal(t, "test", *actual.Extension[0].ValueString)
+}
@@ -0,0 +1,273 @@
+package mapper
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"io"
+	"strings"
+)
+
+const (
+	EncodingJson       = "json"
+	EncodingJsonPretty = "json-pretty"
+	EncodingXml        = "xml"
+
+	ContentTypeJson = "application/fhir+json"
+	ContentTypeXml  = "application/fhir+xml"
+
+	fhirNamespace  = "http://hl7.org/fhir"
+	xhtmlNamespace = "http://www.w3.org/1999/xhtml"
+)
+
+// EncodeBundle serializes the bundle with the given encoding and returns its content type
+func EncodeBundle(bundle *fhir.Bundle, encoding string) ([]byte, string, error) {
+	data, err := bundle.MarshalJSON()
+	if err != nil {
+		return nil, "", err
+	}
+
+	switch encoding {
+	case "", EncodingJson:
+		return data, ContentTypeJson, nil
+	case EncodingJsonPretty:
+		var b bytes.Buffer
+		err = json.Indent(&b, data, "", "  ")
+		return b.Bytes(), ContentTypeJson, err
+	case EncodingXml:
+		data, err = JsonToXml(data)
+		return data, ContentTypeXml, err
+	default:
+		return nil, "", fmt.Errorf("unsupported output encoding '%s'", encoding)
+	}
+}
+
+// jsonField is a property of a JSON object. Objects are kept as ordered list of properties, because
+// FHIR XML requires the elements' order of the FHIR specification
+type jsonField struct {
+	Name  string
+	Value any
+}
+
+type jsonObject []jsonField
+
+func (o jsonObject) get(name string) (any, bool) {
+	for _, f := range o {
+		if f.Name == name {
+			return f.Value, true
+		}
+	}
+	return nil, false
+}
+
+// JsonToXml converts a FHIR JSON resource to FHIR XML
+func JsonToXml(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	v, err := parseJson(dec)
+	if err != nil {
+		return nil, err
+	}
+	resource, ok := v.(jsonObject)
+	if !ok {
+		return nil, errors.New("FHIR resource must be a JSON object")
+	}
+
+	var buf bytes.Buffer
+	w := &xmlWriter{buf: &buf, enc: xml.NewEncoder(&buf)}
+	if err = w.writeResource(resource); err != nil {
+		return nil, err
+	}
+	if err = w.enc.Flush(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func parseJson(dec *json.Decoder) (any, error) {
+	t, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch d := t.(type) {
+	case json.Delim:
+		switch d {
+		case '{':
+			var obj jsonObject
+			for dec.More() {
+				key, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				value, err := parseJson(dec)
+				if err != nil {
+					return nil, err
+				}
+				obj = append(obj, jsonField{Name: key.(string), Value: value})
+			}
+			_, err = dec.Token()
+			return obj, err
+		case '[':
+			var arr []any
+			for dec.More() {
+				value, err := parseJson(dec)
+				if err != nil {
+					return nil, err
+				}
+				arr = append(arr, value)
+			}
+			_, err = dec.Token()
+			return arr, err
+		}
+		return nil, fmt.Errorf("unexpected JSON delimiter '%s'", d)
+	default:
+		return t, nil
+	}
+}
+
+type xmlWriter struct {
+	buf *bytes.Buffer
+	enc *xml.Encoder
+}
+
+func (w *xmlWriter) writeResource(resource jsonObject) error {
+	resourceType, ok := resource.get("resourceType")
+	if !ok {
+		return errors.New("FHIR resource is missing resourceType")
+	}
+
+	start := xml.StartElement{
+		Name: xml.Name{Local: fmt.Sprint(resourceType)},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "xmlns"}, Value: fhirNamespace}},
+	}
+	if err := w.enc.EncodeToken(start); err != nil {
+		return err
+	}
+	if err := w.writeProperties(resource, true, false); err != nil {
+		return err
+	}
+	return w.enc.EncodeToken(start.End())
+}
+
+// writeProperties writes the object's properties as child elements. Element ids and extension urls are
+// written as attributes instead
+func (w *xmlWriter) writeProperties(obj jsonObject, isResource, isExtension bool) error {
+	for _, f := range obj {
+		if base, ok := strings.CutPrefix(f.Name, "_"); ok {
+			// primitive extensions without value
+			if _, exists := obj.get(base); !exists {
+				if err := w.writeProperties(jsonObject{{Name: base, Value: nilValues(f.Value)}, f}, false, false); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if f.Name == "resourceType" ||
+			(f.Name == "id" && !isResource) || (f.Name == "url" && isExtension) {
+			continue
+		}
+
+		// primitive extensions
+		ext, _ := obj.get("_" + f.Name)
+
+		if arr, ok := f.Value.([]any); ok {
+			extArr, _ := ext.([]any)
+			for i, v := range arr {
+				var e any
+				if i < len(extArr) {
+					e = extArr[i]
+				}
+				if err := w.writeElement(f.Name, v, e); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		if err := w.writeElement(f.Name, f.Value, ext); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *xmlWriter) writeElement(name string, value any, ext any) error {
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+	isExtension := name == "extension" || name == "modifierExtension"
+
+	switch v := value.(type) {
+	case jsonObject:
+		if _, ok := v.get("resourceType"); ok {
+			// contained or bundled resource
+			if err := w.enc.EncodeToken(start); err != nil {
+				return err
+			}
+			if err := w.writeResource(v); err != nil {
+				return err
+			}
+			return w.enc.EncodeToken(start.End())
+		}
+
+		start.Attr = appendAttr(start.Attr, "id", v)
+		if isExtension {
+			start.Attr = appendAttr(start.Attr, "url", v)
+		}
+		if err := w.enc.EncodeToken(start); err != nil {
+			return err
+		}
+		if err := w.writeProperties(v, false, isExtension); err != nil {
+			return err
+		}
+		return w.enc.EncodeToken(start.End())
+
+	default:
+		if name == "div" {
+			return w.writeXhtml(fmt.Sprint(v))
+		}
+
+		extObj, _ := ext.(jsonObject)
+		start.Attr = appendAttr(start.Attr, "id", extObj)
+		if v != nil {
+			start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "value"}, Value: fmt.Sprint(v)})
+		}
+		if err := w.enc.EncodeToken(start); err != nil {
+			return err
+		}
+		if err := w.writeProperties(extObj, false, false); err != nil {
+			return err
+		}
+		return w.enc.EncodeToken(start.End())
+	}
+}
+
+// writeXhtml writes the narrative, which is XHTML already
+func (w *xmlWriter) writeXhtml(div string) error {
+	if err := w.enc.Flush(); err != nil {
+		return err
+	}
+	if !strings.Contains(div, "xmlns") {
+		div = strings.Replace(div, "<div", `<div xmlns="`+xhtmlNamespace+`"`, 1)
+	}
+	_, err := io.WriteString(w.buf, div)
+	return err
+}
+
+// nilValues returns null values matching the primitive extensions
+func nilValues(ext any) any {
+	if arr, ok := ext.([]any); ok {
+		return make([]any, len(arr))
+	}
+	return nil
+}
+
+func appendAttr(attrs []xml.Attr, name string, obj jsonObject) []xml.Attr {
+	if v, ok := obj.get(name); ok {
+		return append(attrs, xml.Attr{Name: xml.Name{Local: name}, Value: fmt.Sprint(v)})
+	}
+	return attrs
+}
@@ -0,0 +1,122 @@
+package mapper
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+)
+
+func TestJsonToXml(t *testing.T) {
+	cases := []struct {
+		name     string
+		json     string
+		expected string
+	}{
+		{
+			name:     "primitives",
+			json:     `{"resourceType":"Patient","id":"1","active":true,"multipleBirthInteger":2}`,
+			expected: `<Patient xmlns="http://hl7.org/fhir"><id value="1"></id><active value="true"></active><multipleBirthInteger value="2"></multipleBirthInteger></Patient>`,
+		},
+		{
+			name:     "complex and repeating",
+			json:     `{"resourceType":"Patient","identifier":[{"system":"a","value":"1"},{"id":"x","value":"2"}]}`,
+			expected: `<Patient xmlns="http://hl7.org/fhir"><identifier><system value="a"></system><value value="1"></value></identifier><identifier id="x"><value value="2"></value></identifier></Patient>`,
+		},
+		{
+			name:     "extension",
+			json:     `{"resourceType":"Consent","extension":[{"url":"http://ext","valueString":"v"}]}`,
+			expected: `<Consent xmlns="http://hl7.org/fhir"><extension url="http://ext"><valueString value="v"></valueString></extension></Consent>`,
+		},
+		{
+			name:     "primitive extension",
+			json:     `{"resourceType":"Patient","birthDate":"2000-01-01","_birthDate":{"id":"b","extension":[{"url":"http://ext","valueBoolean":true}]},"_gender":{"extension":[{"url":"http://absent","valueCode":"unknown"}]}}`,
+			expected: `<Patient xmlns="http://hl7.org/fhir"><birthDate id="b" value="2000-01-01"><extension url="http://ext"><valueBoolean value="true"></valueBoolean></extension></birthDate><gender><extension url="http://absent"><valueCode value="unknown"></valueCode></extension></gender></Patient>`,
+		},
+		{
+			name:     "nested resource",
+			json:     `{"resourceType":"Bundle","type":"transaction","entry":[{"resource":{"resourceType":"Patient","id":"1"},"request":{"method":"POST","url":"Patient"}}]}`,
+			expected: `<Bundle xmlns="http://hl7.org/fhir"><type value="transaction"></type><entry><resource><Patient xmlns="http://hl7.org/fhir"><id value="1"></id></Patient></resource><request><method value="POST"></method><url value="Patient"></url></request></entry></Bundle>`,
+		},
+		{
+			name:     "narrative",
+			json:     `{"resourceType":"Patient","text":{"status":"generated","div":"<div>a &amp; b</div>"}}`,
+			expected: `<Patient xmlns="http://hl7.org/fhir"><text><status value="generated"></status><div xmlns="http://www.w3.org/1999/xhtml">a &amp; b</div></text></Patient>`,
+		},
+		{
+			name:     "escaping",
+			json:     `{"resourceType":"Patient","id":"<&>"}`,
+			expected: `<Patient xmlns="http://hl7.org/fhir"><id value="&lt;&amp;&gt;"></id></Patient>`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			actual, err := JsonToXml([]byte(c.json))
+
+			assert.NoError(t, err)
+			assert.Equal(t, c.expected, string(actual))
+		})
+	}
+}
+
+func TestJsonToXml_Invalid(t *testing.T) {
+	for _, data := range []string{`[]`, `{"id":"1"}`, `{"resourceType":`} {
+		_, err := JsonToXml([]byte(data))
+
+		assert.Error(t, err, data)
+	}
+}
+
+func TestEncodeBundle(t *testing.T) {
+	m := createTestMapper()
+	m.Client = &TestGicsClient{
+		respFilePath: "testdata/current-policies-response.json",
+	}
+	bundle, _ := m.Map(context.Background(), createTestNotification())
+
+	cases := []struct {
+		encoding    string
+		contentType string
+	}{
+		{EncodingJson, ContentTypeJson},
+		{"", ContentTypeJson},
+		{EncodingJsonPretty, ContentTypeJson},
+		{EncodingXml, ContentTypeXml},
+	}
+
+	for _, c := range cases {
+		t.Run(c.encoding, func(t *testing.T) {
+			data, contentType, err := EncodeBundle(bundle, c.encoding)
+
+			assert.NoError(t, err)
+			assert.Equal(t, c.contentType, contentType)
+			if c.contentType == ContentTypeXml {
+				assert.NoError(t, wellFormed(data))
+			} else {
+				actual, err := fhir.UnmarshalBundle(data)
+				assert.NoError(t, err)
+				assert.Len(t, actual.Entry, len(bundle.Entry))
+			}
+		})
+	}
+
+	_, _, err := EncodeBundle(bundle, "turtle")
+	assert.Error(t, err)
+}
+
+func wellFormed(data []byte) error {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		_, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
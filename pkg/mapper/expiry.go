@@ -0,0 +1,154 @@
+package mapper
+
+import (
+	"consent-to-fhir/pkg/config"
+	"fmt"
+	"github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"strings"
+	"time"
+)
+
+const originalEndExtensionUrl = "https://fhir.diz.uni-marburg.de/fhir/StructureDefinition/consent-original-period-end"
+
+// defaultSentinels are gICS end dates meaning 'no expiry'
+var defaultSentinels = []string{"3000-01-01"}
+
+// expiryRule recognises period end dates, which mean 'no expiry'
+type expiryRule struct {
+	sentinels      []string
+	thresholdYears int
+}
+
+// expiryRule returns the configured rule for the domain
+func (m *GicsMapper) expiryRule(domain string) expiryRule {
+	e := m.Config.Domains[domain].Expiry
+	if len(e.Sentinels) == 0 {
+		return expiryRule{sentinels: defaultSentinels, thresholdYears: e.ThresholdYears}
+	}
+	return expiryRule{sentinels: e.Sentinels, thresholdYears: e.ThresholdYears}
+}
+
+// validateExpiry checks the configured sentinel dates of all domains
+func validateExpiry(domains map[string]config.Domain) error {
+	for name, d := range domains {
+		for _, s := range d.Expiry.Sentinels {
+			if _, err := time.Parse(time.DateOnly, s); err != nil {
+				return fmt.Errorf("invalid expiry sentinel date of domain '%s': %w", name, err)
+			}
+		}
+		if d.Expiry.ThresholdYears < 0 {
+			return fmt.Errorf("invalid expiry threshold of domain '%s': %d", name, d.Expiry.ThresholdYears)
+		}
+	}
+	return nil
+}
+
+// isNoExpiry checks, if the end date is a sentinel date or more than the threshold years after the start date
+// (or now, if there is no start date)
+func (r expiryRule) isNoExpiry(start *time.Time, end time.Time) bool {
+	date := end.Format(time.DateOnly)
+	for _, s := range r.sentinels {
+		if s == date {
+			return true
+		}
+	}
+
+	if r.thresholdYears > 0 {
+		from := time.Now()
+		if start != nil {
+			from = *start
+		}
+		return end.After(from.AddDate(r.thresholdYears, 0, 0))
+	}
+	return false
+}
+
+// fixNoExpiryDate removes the period's end date, if it means 'no expiry'. The original end date is kept as an extension
+func fixNoExpiryDate(period *fhir.Period, rule expiryRule) *fhir.Period {
+	if period == nil || period.End == nil {
+		return period
+	}
+
+	end := parseTime(period.End)
+	if end == nil {
+		return period
+	}
+	var start *time.Time
+	if period.Start != nil {
+		start = parseTime(period.Start)
+	}
+
+	if !rule.isNoExpiry(start, *end) {
+		return period
+	}
+
+	return &fhir.Period{
+		Extension: append(period.Extension, fhir.Extension{
+			Url:           originalEndExtensionUrl,
+			ValueDateTime: period.End,
+		}),
+		Start: period.Start,
+		End:   nil,
+	}
+}
+
+// removeExpired removes provisions, which ended before the given date. The consent is set inactive, if its period ended
+func removeExpired(c fhir.Consent, at time.Time) fhir.Consent {
+	if c.Provision == nil {
+		return c
+	}
+
+	var provisions []fhir.ConsentProvision
+	for _, p := range c.Provision.Provision {
+		if !hasEnded(p.Period, at) {
+			provisions = append(provisions, p)
+		}
+	}
+	c.Provision.Provision = provisions
+
+	if hasEnded(c.Provision.Period, at) {
+		c.Status = fhir.ConsentStateInactive
+	}
+	return c
+}
+
+func hasEnded(period *fhir.Period, at time.Time) bool {
+	if period == nil || period.End == nil {
+		return false
+	}
+	end := parseTime(period.End)
+	return end != nil && !end.After(at)
+}
+
+// NextExpiry returns the earliest provision period end of the bundle's Consent resources after the given date
+func NextExpiry(bundle *fhir.Bundle, after time.Time) (time.Time, bool) {
+	var next time.Time
+	found := false
+
+	consider := func(period *fhir.Period) {
+		if period == nil || period.End == nil {
+			return
+		}
+		end := parseTime(period.End)
+		if end != nil && end.After(after) && (!found || end.Before(next)) {
+			next, found = *end, true
+		}
+	}
+
+	for _, e := range bundle.Entry {
+		if e.Resource == nil || e.Request == nil || e.Request.Method != fhir.HTTPVerbPUT ||
+			!strings.HasPrefix(e.Request.Url, "Consent") {
+			continue
+		}
+		c, err := fhir.UnmarshalConsent(e.Resource)
+		if err != nil || c.Provision == nil {
+			continue
+		}
+		consider(c.Provision.Period)
+		for _, p := range c.Provision.Provision {
+			consider(p.Period)
+		}
+	}
+
+	return next, found
+}
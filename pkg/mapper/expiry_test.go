@@ -0,0 +1,162 @@
+package mapper
+
+import (
+	"consent-to-fhir/pkg/config"
+	"context"
+	"github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestFixNoExpiryDate(t *testing.T) {
+	cases := []struct {
+		name    string
+		expiry  config.Expiry
+		end     string
+		cleared bool
+	}{
+		{
+			name:    "default sentinel",
+			end:     "3000-01-01T00:00:00+01:00",
+			cleared: true,
+		},
+		{
+			name:    "default end date",
+			end:     "2053-12-11T00:00:00+01:00",
+			cleared: false,
+		},
+		{
+			name:    "configured sentinel",
+			expiry:  config.Expiry{Sentinels: []string{"9999-12-31"}},
+			end:     "9999-12-31T00:00:00+01:00",
+			cleared: true,
+		},
+		{
+			name:    "configured sentinel replaces default",
+			expiry:  config.Expiry{Sentinels: []string{"9999-12-31"}},
+			end:     "3000-01-01T00:00:00+01:00",
+			cleared: false,
+		},
+		{
+			name:    "beyond threshold",
+			expiry:  config.Expiry{ThresholdYears: 25},
+			end:     "2053-12-11T00:00:00+01:00",
+			cleared: true,
+		},
+		{
+			name:    "within threshold",
+			expiry:  config.Expiry{ThresholdYears: 30},
+			end:     "2053-12-11T00:00:00+01:00",
+			cleared: false,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			m := &GicsMapper{Config: config.Mapper{Domains: map[string]config.Domain{"MII": {Expiry: c.expiry}}}}
+			period := &fhir.Period{Start: Of("2023-12-11T00:00:00+01:00"), End: Of(c.end)}
+
+			actual := fixNoExpiryDate(period, m.expiryRule("MII"))
+
+			if c.cleared {
+				assert.Nil(t, actual.End)
+				assert.Equal(t, []fhir.Extension{{Url: originalEndExtensionUrl, ValueDateTime: Of(c.end)}}, actual.Extension)
+			} else {
+				assert.Equal(t, period, actual)
+			}
+		})
+	}
+}
+
+func TestFixNoExpiryDate_InvalidEnd(t *testing.T) {
+	period := &fhir.Period{End: Of("3000-01-01")}
+
+	assert.Equal(t, period, fixNoExpiryDate(period, expiryRule{sentinels: defaultSentinels}))
+}
+
+func TestValidateExpiry(t *testing.T) {
+	assert.NoError(t, validateExpiry(map[string]config.Domain{"MII": {Expiry: config.Expiry{Sentinels: []string{"9999-12-31"}}}}))
+	assert.Error(t, validateExpiry(map[string]config.Domain{"MII": {Expiry: config.Expiry{Sentinels: []string{"31.12.9999"}}}}))
+	assert.Error(t, validateExpiry(map[string]config.Domain{"MII": {Expiry: config.Expiry{ThresholdYears: -1}}}))
+}
+
+func TestNextExpiry(t *testing.T) {
+	m := createTestMapper()
+	m.Client = &TestGicsClient{
+		respFilePath: "testdata/current-policies-response.json",
+	}
+	bundle, _ := m.Map(context.Background(), createTestNotification())
+
+	cases := []struct {
+		name     string
+		after    string
+		expected string
+		found    bool
+	}{
+		{
+			name:     "earliest",
+			after:    "2024-01-01T00:00:00+01:00",
+			expected: "2028-12-11T00:00:00+01:00",
+			found:    true,
+		},
+		{
+			name:     "next",
+			after:    "2028-12-11T00:00:00+01:00",
+			expected: "2053-12-11T00:00:00+01:00",
+			found:    true,
+		},
+		{
+			name:  "none",
+			after: "2053-12-11T00:00:00+01:00",
+			found: false,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			actual, found := NextExpiry(bundle, *parseTime(&c.after))
+
+			assert.Equal(t, c.found, found)
+			if c.found {
+				assert.True(t, parseTime(&c.expected).Equal(actual))
+			}
+		})
+	}
+}
+
+func TestReevaluate(t *testing.T) {
+	m := createTestMapper()
+	m.Client = &TestGicsClient{
+		respFilePath: "testdata/current-policies-response.json",
+	}
+
+	before, _ := m.Map(context.Background(), createTestNotification())
+	after, _ := m.Reevaluate(context.Background(), createTestNotification(), *parseTime(Of("2028-12-11T00:00:00+01:00")))
+	beforeConsent, _ := fhir.UnmarshalConsent(before.Entry[0].Resource)
+	afterConsent, _ := fhir.UnmarshalConsent(after.Entry[0].Resource)
+
+	assert.Len(t, afterConsent.Provision.Provision, len(beforeConsent.Provision.Provision)-1)
+	assert.Equal(t, fhir.ConsentStateActive, afterConsent.Status)
+}
+
+func TestRemoveExpired(t *testing.T) {
+	c := fhir.Consent{
+		Status: fhir.ConsentStateActive,
+		Provision: &fhir.ConsentProvision{
+			Period: &fhir.Period{End: Of("2053-12-11T00:00:00+01:00")},
+			Provision: []fhir.ConsentProvision{
+				{Period: &fhir.Period{End: Of("2028-12-11T00:00:00+01:00")}},
+				{Period: &fhir.Period{End: Of("2053-12-11T00:00:00+01:00")}},
+				{},
+			},
+		},
+	}
+
+	actual := removeExpired(c, *parseTime(Of("2030-01-01T00:00:00Z")))
+	assert.Len(t, actual.Provision.Provision, 2)
+	assert.Equal(t, fhir.ConsentStateActive, actual.Status)
+
+	actual = removeExpired(actual, *parseTime(Of("2053-12-11T00:00:00+01:00")))
+	assert.Len(t, actual.Provision.Provision, 1)
+	assert.Equal(t, fhir.ConsentStateInactive, actual.Status)
+}
@@ -4,29 +4,91 @@ import (
 	"consent-to-fhir/pkg/client"
 	"consent-to-fhir/pkg/config"
 	"consent-to-fhir/pkg/model"
+	"context"
 	"crypto"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/samply/golang-fhir-models/fhir-models/fhir"
 	log "github.com/sirupsen/logrus"
+	"strings"
+	"sync"
 	"time"
 )
 
+// consentInfo holds the notification data required to map consent resources
+type consentInfo struct {
+	domain        string
+	signerId      model.SignerId
+	patientSystem string
+	date          string
+	evaluatedAt   *time.Time
+	others        []model.SignerId
+	template      *model.ConsentTemplateKey
+	qc            *model.Qc
+	qcPending     bool
+}
+
 type GicsMapper struct {
-	Client client.GicsClient
-	Config config.Mapper
+	Client   client.GicsClient
+	Direct   *NotificationMapper
+	CodeMap  *PolicyCodeMap
+	Resolver client.IdentityResolver
+	History  HistoryStore
+	// Profiles are the mapper's profile mappers by profile url, taking precedence over registered ones
+	Profiles map[string]ProfileMapper
+	Config   config.Mapper
+
+	// pending are the new consent versions of mapped bundles, which have not been delivered yet
+	pending sync.Map
+	// domainRefs are the gICS ResearchStudy references of mapped consents by domain
+	domainRefs sync.Map
 }
 
 func NewGicsMapper(c config.AppConfig) *GicsMapper {
+	codeMap, err := LoadConceptMaps(c.App.Mapper.ConceptMaps)
+	if err != nil {
+		log.WithError(err).Fatal("Failed to load policy ConceptMaps")
+	}
+	if err := validateConsentId(c.App.Mapper.ConsentId); err != nil {
+		log.WithError(err).Fatal("Invalid consent id configuration")
+	}
+	if err := validateExpiry(c.App.Mapper.Domains); err != nil {
+		log.WithError(err).Fatal("Invalid expiry configuration")
+	}
+	if err := validateSource(c.App.Mapper); err != nil {
+		log.WithError(err).Fatal("Invalid source configuration")
+	}
+	if err := validateWithdrawal(c.App.Mapper); err != nil {
+		log.WithError(err).Fatal("Invalid withdrawal configuration")
+	}
+	if v := c.App.Mapper.OutputVersion; v != "" && v != OutputR4 && v != OutputR5 {
+		log.WithField("version", v).Fatal("Unsupported FHIR output version")
+	}
+	resolver, err := client.NewIdentityResolver(c)
+	if err != nil {
+		log.WithError(err).Fatal("Failed to create identity resolver")
+	}
+	var history HistoryStore
+	if c.App.Mapper.History.Enabled {
+		history, err = NewFileHistoryStore(c.App.Mapper.History.Store)
+		if err != nil {
+			log.WithError(err).Fatal("Failed to load consent history")
+		}
+	}
 
 	return &GicsMapper{
-		Client: client.NewGicsClient(c),
-		Config: c.App.Mapper,
+		Client:   client.NewGicsClient(c),
+		Direct:   NewNotificationMapper(c.App.Mapper, codeMap),
+		CodeMap:  codeMap,
+		Resolver: resolver,
+		History:  history,
+		Profiles: map[string]ProfileMapper{MiiProfile: NewMiiProfileMapper(c.App.Mapper.Templates, c.App.Mapper.StrictTemplates)},
+		Config:   c.App.Mapper,
 	}
 }
 
-func (m *GicsMapper) Process(data []byte) *fhir.Bundle {
+func (m *GicsMapper) Process(ctx context.Context, data []byte) *fhir.Bundle {
 	var n model.Notification
 	err := json.Unmarshal(data, &n)
 	if err != nil {
@@ -34,55 +96,138 @@ func (m *GicsMapper) Process(data []byte) *fhir.Bundle {
 		return nil
 	}
 
-	bundle, err := m.toFhir(n)
+	bundle, err := m.Map(ctx, n)
 	if err != nil {
 		log.WithError(err).Error("Failed to map consent")
 		return nil
 	}
 
+	// delivery is not tracked, the consent version is not recorded
+	m.pending.Delete(bundle)
 	return bundle
 }
 
-func (m *GicsMapper) toFhir(n model.Notification) (*fhir.Bundle, error) {
+// Map maps the notification to a FHIR transaction bundle
+func (m *GicsMapper) Map(ctx context.Context, n model.Notification) (*fhir.Bundle, error) {
+	return m.mapAt(ctx, n, nil)
+}
+
+// Reevaluate maps the notification with the consent state at the given date. Provisions, which ended
+// before this date, are removed
+func (m *GicsMapper) Reevaluate(ctx context.Context, n model.Notification, at time.Time) (*fhir.Bundle, error) {
+	return m.mapAt(ctx, n, &at)
+}
+
+func (m *GicsMapper) mapAt(ctx context.Context, n model.Notification, at *time.Time) (*fhir.Bundle, error) {
+	if n.ConsentKey == nil || n.ConsentKey.ConsentTemplateKey == nil || n.ConsentKey.ConsentTemplateKey.DomainName == nil {
+		return nil, errors.New("notification is missing consent key data")
+	}
 
-	signerId := n.ConsentKey.SignerIds[0]
 	domain := *n.ConsentKey.ConsentTemplateKey.DomainName
+	signerId, others, err := selectSigner(n.ConsentKey.SignerIds, m.Config.Domains[domain].Signer)
+	if err != nil {
+		return nil, err
+	}
+	info := consentInfo{
+		domain:        domain,
+		signerId:      signerId,
+		patientSystem: patientSystemOf(m.Config, domain),
+		others:        others,
+		template:      n.ConsentKey.ConsentTemplateKey,
+		evaluatedAt:   at,
+		qcPending:     qcPending(n),
+	}
+	if n.Context != nil {
+		info.qc = n.Context.Qc
+	}
+	if n.ConsentKey.ConsentDate != nil {
+		info.date, err = parseConsentDate(*n.ConsentKey.ConsentDate)
+		if err != nil {
+			info.date = *n.ConsentKey.ConsentDate
+		}
+	}
 
-	// get current consent state from gics
-	bundle, err := m.Client.GetConsentStatus(
-		signerId,
-		*n.ConsentKey.ConsentTemplateKey.DomainName,
-		*n.ConsentKey.ConsentDate,
-	)
+	if info.qcPending && m.qcPolicy(domain) == QcPolicyHold {
+		log.WithFields(log.Fields{"domain": domain, "id": signerId.Id}).
+			Info("Consent has not passed quality control. Holding back")
+		return nil, nil
+	}
+
+	var bundle *fhir.Bundle
+	if m.isDirect(domain) {
+		// map consent state from notification data
+		bundle, err = m.Direct.GetConsentStatus(n)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		// get current consent state from gics
+		var requestDate string
+		switch {
+		case at != nil:
+			requestDate = at.In(time.Local).Format(time.DateTime)
+		case n.ConsentKey.ConsentDate != nil && strings.TrimSpace(*n.ConsentKey.ConsentDate) != "":
+			requestDate = *n.ConsentKey.ConsentDate
+		default:
+			return nil, errors.New("notification is missing the consent date")
+		}
+		bundle, err = m.Client.GetConsentStatus(ctx,
+			signerId,
+			*n.ConsentKey.ConsentTemplateKey.DomainName,
+			requestDate,
+		)
+		if err != nil {
+			log.Error("Request to get consent status from gICS failed")
+			return nil, err
+		}
+	}
+
+	// replace signer id with pseudonym
+	info, err = m.resolveSigner(ctx, info)
 	if err != nil {
-		log.Error("Request to get consent status from gICS failed")
 		return nil, err
 	}
 
 	// map resources
-	return m.mapResources(bundle, domain, signerId.Id)
+	return m.mapResources(ctx, bundle, info)
 }
 
 func (m *GicsMapper) createDeleteBundle(domain, signerId string) (*fhir.Bundle, error) {
-	id := hash(domain, signerId)
+	id := m.consentId(domain, signerId)
 
 	// build Bundle
-	return &fhir.Bundle{
+	bundle := &fhir.Bundle{
 		Type: fhir.BundleTypeTransaction,
 		Entry: []fhir.BundleEntry{
 			{
 				Request: &fhir.BundleEntryRequest{
 					Method: fhir.HTTPVerbDELETE,
-					Url:    fmt.Sprintf("Consent?identifier=%s|%s", *m.Config.ConsentSystem, id),
-				}}}}, nil
+					Url:    fmt.Sprintf("Consent?identifier=%s|%s", *consentSystemOf(m.Config, domain), id),
+				}}}}
+
+	// delete consent with previous identifier
+	if e := m.migrationEntry(domain, signerId); e != nil {
+		bundle.Entry = append(bundle.Entry, *e)
+	}
+
+	return bundle, nil
 }
 
-func (m *GicsMapper) mapResources(bundle *fhir.Bundle, domain string, pid string) (*fhir.Bundle, error) {
+func (m *GicsMapper) mapResources(ctx context.Context, bundle *fhir.Bundle, info consentInfo) (*fhir.Bundle, error) {
+	domain := info.domain
+	pid := info.signerId.Id
 
 	// check bundle
 	if len(bundle.Entry) == 0 {
 
 		// no consent resources found indicates invalidation (or inconsistent data)
+		if m.withdrawal(domain) == WithdrawalInactive || m.isHistory() {
+			log.WithField("id", pid).Warn("No Consent resource found in gICS FHIR bundle. " +
+				"Consent may have been invalidated. Creating inactive consent")
+
+			return m.createWithdrawalBundle(ctx, info)
+		}
+
 		log.WithField("id", pid).Warn("No Consent resource found in gICS FHIR bundle. "+
 			"Consent may have been invalidated. Creating delete request", "id", pid)
 
@@ -94,25 +239,75 @@ func (m *GicsMapper) mapResources(bundle *fhir.Bundle, domain string, pid string
 	c, _ := fhir.UnmarshalConsent(bundle.Entry[0].Resource)
 	c.Provision = &fhir.ConsentProvision{
 		Type:      Of(fhir.ConsentProvisionTypeDeny),
-		Period:    fixNoExpiryDate(c.Provision.Period),
-		Provision: mergePolicies(bundle.Entry),
+		Period:    fixNoExpiryDate(c.Provision.Period, m.expiryRule(domain)),
+		Provision: mergePolicies(bundle.Entry, m.codingMapper(domain), m.expiryRule(domain)),
+	}
+	if info.evaluatedAt != nil {
+		c = removeExpired(c, *info.evaluatedAt)
 	}
 
 	domainRef := m.getDomainReference(c.Extension)
+	if domainRef != nil {
+		m.domainRefs.Store(domain, *domainRef)
+	}
+	sourceRef := c.SourceReference
 
 	// map
-	r := m.mapConsent(c, domain, pid)
-	data, err := r.MarshalJSON()
+	r, err := m.mapConsent(c, info)
 	if err != nil {
 		return nil, err
 	}
+	if info.qcPending && m.qcPolicy(domain) == QcPolicyProposed {
+		r.Status = fhir.ConsentStateProposed
+	}
+
+	// re-home source QuestionnaireResponse
+	var sourceEntry *fhir.BundleEntry
+	if m.Config.KeepSource && sourceRef != nil && sourceRef.Reference != nil {
+		entry, ref, err := m.mapSource(ctx, *sourceRef.Reference, r.Patient)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get source QuestionnaireResponse resource '%s': %w", *sourceRef.Reference, err)
+		}
+		sourceEntry = entry
+		r.SourceReference = ref
+	}
 
 	// create domain reference (ResearchSubject)
-	study, err := m.Client.GetConsentDomain(*domainRef)
+	study, err := m.getConsentDomain(ctx, domain, domainRef)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get ResearchStudy resource for domain '%s': %w", domain, err)
 	}
 
+	result, err := m.createBundle(r, info, study)
+	if err != nil {
+		return nil, err
+	}
+	if sourceEntry != nil {
+		result.Entry = append(result.Entry, *sourceEntry)
+	}
+
+	return result, nil
+}
+
+func (m *GicsMapper) createBundle(r fhir.Consent, info consentInfo, study *fhir.ResearchStudy) (*fhir.Bundle, error) {
+	domain := info.domain
+
+	// mark previous consent version
+	var previous *fhir.BundleEntry
+	var version *ConsentVersion
+	if m.isHistory() {
+		var err error
+		r, previous, version, err = m.versionConsent(r, info)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := r.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+
 	studyData, err := fhir.ResearchStudy{
 		Identifier: []fhir.Identifier{{
 			System: m.Config.DomainSystem,
@@ -127,14 +322,14 @@ func (m *GicsMapper) mapResources(bundle *fhir.Bundle, domain string, pid string
 	}
 
 	// build Bundle
-	return &fhir.Bundle{
+	bundle := &fhir.Bundle{
 		Type: fhir.BundleTypeTransaction,
 		Entry: []fhir.BundleEntry{
 			{
 				Resource: data,
 				Request: &fhir.BundleEntryRequest{
 					Method: fhir.HTTPVerbPUT,
-					Url:    fmt.Sprintf("Consent?identifier=%s|%s", *m.Config.ConsentSystem, *r.Id),
+					Url:    fmt.Sprintf("Consent?identifier=%s|%s", *consentSystemOf(m.Config, domain), *r.Id),
 				},
 			},
 			{
@@ -145,45 +340,133 @@ func (m *GicsMapper) mapResources(bundle *fhir.Bundle, domain string, pid string
 					Url:         "ResearchStudy",
 				},
 			},
-		}}, nil
+		}}
+
+	if previous != nil {
+		bundle.Entry = append(bundle.Entry, *previous)
+	}
+
+	// delete consent with previous identifier
+	if e := m.migrationEntry(domain, info.signerId.Id); e != nil {
+		bundle.Entry = append(bundle.Entry, *e)
+	}
+
+	// create Patient, if not exists
+	if m.patientStub(domain) {
+		patient, err := m.createPatientEntry(info)
+		if err != nil {
+			return nil, err
+		}
+		bundle.Entry = append(bundle.Entry, *patient)
+	}
+
+	// recorded after delivery
+	if version != nil {
+		m.pending.Store(bundle, *version)
+	}
+
+	return bundle, nil
+}
+
+func (m *GicsMapper) isDirect(domain string) bool {
+	return m.Config.Domains[domain].Mode == NotificationMode
 }
 
-func (m *GicsMapper) mapConsent(c fhir.Consent, domain string, pid string) fhir.Consent {
+func (m *GicsMapper) getConsentDomain(ctx context.Context, domain string, domainRef *string) (*fhir.ResearchStudy, error) {
+	if m.isDirect(domain) {
+		return m.Direct.GetConsentDomain(domain), nil
+	}
+	if domainRef == nil {
+		return nil, errors.New("missing domain reference")
+	}
+
+	return m.Client.GetConsentDomain(ctx, *domainRef)
+}
+
+func (m *GicsMapper) mapConsent(c fhir.Consent, info consentInfo) (fhir.Consent, error) {
+	domain := info.domain
+	pid := info.signerId.Id
+
 	// set id
-	id := hash(domain, pid)
+	id := m.consentId(domain, pid)
+	if m.isHistory() {
+		id = m.versionId(info)
+	}
 	c.Id = &id
 
 	// set profile and do custom mapping
-	if p, ok := m.Config.Profiles[domain]; ok {
+	if p, ok := profileOf(m.Config, domain); ok {
 		c.Meta.Profile = []string{p}
 
 		// map to profile
-		c = MapProfile(c)
+		var err error
+		c, err = m.mapProfile(c, p, info.template)
+		if err != nil {
+			return c, err
+		}
 	}
 
-	// set identifier
-	c.Identifier = []fhir.Identifier{{
-		System: m.Config.ConsentSystem,
+	// set identifier and additional signer identifiers
+	c.Identifier = append([]fhir.Identifier{{
+		System: consentSystemOf(m.Config, domain),
 		Value:  &id,
-	}}
+	}}, signerIdentifiers(info.others, m.Config.Domains[domain].Signer)...)
 
 	// remove policyRule and source reference
 	c.PolicyRule = nil
 	c.SourceReference = nil
 
 	// set patient
-	p := fmt.Sprintf("Patient?identifier=%s|%s", *m.Config.PatientSystem, pid)
 	c.Patient = &fhir.Reference{
-		Reference: &p,
+		Reference: Of(m.patientReference(info)),
 	}
 
-	// set domain extension
+	// set domain and quality control extensions
 	c.Extension = m.setDomainExtension(c.Extension, domain)
+	c.Extension = setQcExtension(c.Extension, info.qc)
+
+	return c, nil
+}
+
+// mapProfile maps the consent with the mapper's or the registered ProfileMapper of the profile. Fails, if the
+// profile mapper does not support the consent template
+func (m *GicsMapper) mapProfile(c fhir.Consent, profile string, template *model.ConsentTemplateKey) (fhir.Consent, error) {
+	pm, ok := m.Profiles[profile]
+	if !ok {
+		pm, ok = GetProfileMapper(profile)
+	}
+	if !ok {
+		return c, nil
+	}
+
+	if tc, ok := pm.(TemplateChecker); ok {
+		if err := tc.Check(template); err != nil {
+			return c, err
+		}
+	}
+	return mapProfile(pm, c, template), nil
+}
+
+// codingMapper picks a single coding from provision codes either by the configured policy ConceptMaps
+// or by convention. Codes without a ConceptMap mapping are reported
+func (m *GicsMapper) codingMapper(domain string) func([]fhir.CodeableConcept) *fhir.Coding {
+	profile, _ := profileOf(m.Config, domain)
+	if !m.CodeMap.Applies(domain, profile) {
+		return getSingleCoding
+	}
 
-	return c
+	return func(codes []fhir.CodeableConcept) *fhir.Coding {
+		coding, ok := m.CodeMap.Map(domain, profile, codes)
+		if !ok {
+			log.WithFields(log.Fields{"domain": domain, "profile": profile, "code": policyCode(codes)}).
+				Warn("No ConceptMap mapping found for policy code. Provision is dropped")
+		}
+		return coding
+	}
 }
 
-func mergePolicies(entries []fhir.BundleEntry) []fhir.ConsentProvision {
+func mergePolicies(entries []fhir.BundleEntry, mapCoding func([]fhir.CodeableConcept) *fhir.Coding,
+	expiry expiryRule) []fhir.ConsentProvision {
 	var p []fhir.ConsentProvision
 
 	for _, e := range entries {
@@ -201,10 +484,10 @@ func mergePolicies(entries []fhir.BundleEntry) []fhir.ConsentProvision {
 			}
 
 			// fix 'no end date' of provision period
-			pp.Period = fixNoExpiryDate(pp.Period)
+			pp.Period = fixNoExpiryDate(pp.Period, expiry)
 
 			// pick single coding from provision.code
-			coding := getSingleCoding(pp.Code)
+			coding := mapCoding(pp.Code)
 			if coding == nil {
 				// no coding found
 				continue
@@ -218,21 +501,6 @@ func mergePolicies(entries []fhir.BundleEntry) []fhir.ConsentProvision {
 	return p
 }
 
-func fixNoExpiryDate(period *fhir.Period) *fhir.Period {
-	if period != nil && period.End != nil {
-		end := parseTime(period.End)
-		noExpiry := time.Date(3000, 1, 1, 0, 0, 0, 0, time.FixedZone(end.Zone()))
-
-		if noExpiry.Equal(*end) {
-			return &fhir.Period{
-				Start: period.Start,
-				End:   nil,
-			}
-		}
-	}
-	return period
-}
-
 func getSingleCoding(codes []fhir.CodeableConcept) *fhir.Coding {
 	// just pick the last coding of the first code as it is currently the most specific one by convention
 	// this might change in the future
@@ -248,7 +516,7 @@ func getSingleCoding(codes []fhir.CodeableConcept) *fhir.Coding {
 func (m *GicsMapper) setDomainExtension(extensions []fhir.Extension, domain string) []fhir.Extension {
 
 	for _, e := range extensions {
-		if e.Url == "http://fhir.de/ConsentManagement/StructureDefinition/DomainReference" {
+		if e.Url == domainReferenceUrl {
 			refIndex := -1
 			for i, ext := range e.Extension {
 				if ext.Url == "domain" {
@@ -270,7 +538,7 @@ func (m *GicsMapper) setDomainExtension(extensions []fhir.Extension, domain stri
 func (m *GicsMapper) getDomainReference(extensions []fhir.Extension) *string {
 
 	for _, e := range extensions {
-		if e.Url == "http://fhir.de/ConsentManagement/StructureDefinition/DomainReference" {
+		if e.Url == domainReferenceUrl {
 			for _, ext := range e.Extension {
 				if ext.Url == "domain" {
 					return ext.ValueReference.Reference
@@ -3,11 +3,13 @@ package mapper
 import (
 	"consent-to-fhir/pkg/config"
 	"consent-to-fhir/pkg/model"
+	"context"
 	"fmt"
 	"github.com/samply/golang-fhir-models/fhir-models/fhir"
 	"github.com/stretchr/testify/assert"
 	"io"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -56,7 +58,7 @@ func (c *TestGicsClient) Auth() *config.Auth {
 	return nil
 }
 
-func (c *TestGicsClient) GetConsentDomain(_ string) (*fhir.ResearchStudy, error) {
+func (c *TestGicsClient) GetConsentDomain(_ context.Context, _ string) (*fhir.ResearchStudy, error) {
 	return &fhir.ResearchStudy{
 		Identifier: []fhir.Identifier{{
 			System: Of("http://fhir.local/sid/consent-domain-id"),
@@ -68,7 +70,16 @@ func (c *TestGicsClient) GetConsentDomain(_ string) (*fhir.ResearchStudy, error)
 	}, nil
 }
 
-func (c *TestGicsClient) GetConsentStatus(_ model.SignerId, _, _ string) (*fhir.Bundle, error) {
+func (c *TestGicsClient) GetQuestionnaireResponse(_ context.Context, resId string) (*fhir.QuestionnaireResponse, error) {
+	return &fhir.QuestionnaireResponse{
+		Id:            Of(resId[strings.LastIndex(resId, "/")+1:]),
+		Questionnaire: Of("http://ths.local/ttp-fhir/fhir/gics/Questionnaire/test"),
+		Status:        fhir.QuestionnaireResponseStatusCompleted,
+		Subject:       &fhir.Reference{Reference: Of("Patient/162fee4d-76e9-425b-b573-781f11d8367c")},
+	}, nil
+}
+
+func (c *TestGicsClient) GetConsentStatus(_ context.Context, _ model.SignerId, _, _ string) (*fhir.Bundle, error) {
 	testFile, _ := os.Open(c.respFilePath)
 	b, _ := io.ReadAll(testFile)
 	bundle, err := fhir.UnmarshalBundle(b)
@@ -129,7 +140,7 @@ func TestProcess(t *testing.T) {
 			},
 		},
 	}
-	bundle := m.Process(input)
+	bundle := m.Process(context.Background(), input)
 	actual, _ := fhir.UnmarshalConsent(bundle.Entry[0].Resource)
 
 	assert.Equal(t, actual.Meta.Profile, expected.Meta.Profile)
@@ -170,7 +181,7 @@ func TestProcess_MissingConsent(t *testing.T) {
 		Url:    fmt.Sprintf("Consent?identifier=%s|%s", *m.Config.ConsentSystem, condId),
 	}
 
-	bundle := *m.Process(input)
+	bundle := *m.Process(context.Background(), input)
 	actual := *bundle.Entry[0].Request
 
 	assert.Equal(t, actual, expected)
@@ -209,7 +220,7 @@ func TestMergePolicies(t *testing.T) {
 				entries = append(entries, fhir.BundleEntry{Resource: res})
 			}
 
-			p := mergePolicies(entries)
+			p := mergePolicies(entries, getSingleCoding, expiryRule{sentinels: defaultSentinels})
 			var actual []fhir.Coding
 			for _, prov := range p {
 				for _, cc := range prov.Code {
@@ -235,16 +246,20 @@ func TestMergePolicies_NoExpiry(t *testing.T) {
 			expected: fhir.Period{Start: Of("2023-12-11T00:00:00+01:00"), End: Of("2053-12-11T00:00:00+01:00")},
 		},
 		{
-			name:     "fix",
-			period:   fhir.Period{Start: Of("2023-12-11T00:00:00+01:00"), End: Of("3000-01-01T00:00:00+01:00")},
-			expected: fhir.Period{Start: Of("2023-12-11T00:00:00+01:00"), End: nil},
+			name:   "fix",
+			period: fhir.Period{Start: Of("2023-12-11T00:00:00+01:00"), End: Of("3000-01-01T00:00:00+01:00")},
+			expected: fhir.Period{
+				Extension: []fhir.Extension{{Url: originalEndExtensionUrl, ValueDateTime: Of("3000-01-01T00:00:00+01:00")}},
+				Start:     Of("2023-12-11T00:00:00+01:00"),
+				End:       nil,
+			},
 		},
 	}
 
 	for _, c := range cases {
 		t.Run(c.name, func(t *testing.T) {
 
-			actual := fixNoExpiryDate(&c.period)
+			actual := fixNoExpiryDate(&c.period, expiryRule{sentinels: defaultSentinels})
 
 			assert.Equal(t, *actual, c.expected)
 		})
@@ -264,3 +279,63 @@ func createConsentTestData(codings []fhir.Coding, pt fhir.ConsentProvisionType)
 			},
 		})}
 }
+
+func TestProcess_SignerIds(t *testing.T) {
+	m := createTestMapper()
+	m.Config.Domains = map[string]config.Domain{
+		"MII": {Signer: config.Signer{IdTypes: []string{"Studien-Pseudonym"}}},
+	}
+	m.Client = &TestGicsClient{
+		respFilePath: "testdata/current-policies-response.json",
+	}
+	n := createTestNotification()
+	n.ConsentKey.SignerIds = append(n.ConsentKey.SignerIds, model.SignerId{IdType: "Studien-Pseudonym", Id: "psn-42", OrderNumber: Of(1)})
+
+	bundle, _ := m.Map(context.Background(), n)
+	actual, _ := fhir.UnmarshalConsent(bundle.Entry[0].Resource)
+
+	assert.Equal(t, fmt.Sprintf("Patient?identifier=%s|%s", *m.Config.PatientSystem, "psn-42"), *actual.Patient.Reference)
+	assert.Equal(t, []fhir.Identifier{
+		{System: m.Config.ConsentSystem, Value: Of(hash("MII", "psn-42"))},
+		{System: Of("https://ths-greifswald.de/fhir/gics/identifiers/Patienten-ID"), Value: Of("42")},
+	}, actual.Identifier)
+}
+
+func TestMap_NoConsentDate(t *testing.T) {
+	m := createTestMapper()
+	m.Client = &TestGicsClient{
+		respFilePath: "testdata/current-policies-response.json",
+	}
+
+	cases := []struct {
+		name string
+		date *string
+	}{
+		{"missing", nil},
+		{"empty", Of("")},
+		{"blank", Of("  ")},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			n := createTestNotification()
+			n.ConsentKey.ConsentDate = c.date
+
+			bundle, err := m.Map(context.Background(), n)
+
+			assert.Error(t, err)
+			assert.Nil(t, bundle)
+		})
+	}
+}
+
+func TestProcess_NoSignerIds(t *testing.T) {
+	m := createTestMapper()
+	n := createTestNotification()
+	n.ConsentKey.SignerIds = []model.SignerId{}
+
+	bundle, err := m.Map(context.Background(), n)
+
+	assert.Error(t, err)
+	assert.Nil(t, bundle)
+}
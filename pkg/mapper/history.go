@@ -0,0 +1,223 @@
+package mapper
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/samply/golang-fhir-models/fhir-models/fhir"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	HistoryInactive   = "inactive"
+	HistorySuperseded = "superseded"
+
+	supersededExtensionUrl = "https://fhir.diz.uni-marburg.de/fhir/StructureDefinition/consent-superseded-by"
+)
+
+// ConsentVersion is the latest consent version of a signer within a domain
+type ConsentVersion struct {
+	Key  string `json:"key"`
+	Id   string `json:"id"`
+	Date string `json:"date"`
+}
+
+// HistoryStore keeps track of the latest consent versions
+type HistoryStore interface {
+	Get(key string) (ConsentVersion, bool)
+	Put(v ConsentVersion) error
+}
+
+// FileHistoryStore keeps the latest consent versions in memory and appends each version to a
+// JSON lines file, if configured. The file is replayed on startup
+type FileHistoryStore struct {
+	mu       sync.Mutex
+	versions map[string]ConsentVersion
+	file     *os.File
+}
+
+func NewFileHistoryStore(path string) (*FileHistoryStore, error) {
+	s := &FileHistoryStore{versions: make(map[string]ConsentVersion)}
+	if path == "" {
+		return s, nil
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var v ConsentVersion
+		if err := json.Unmarshal(scanner.Bytes(), &v); err != nil {
+			return nil, fmt.Errorf("failed to read consent history file '%s': %w", path, err)
+		}
+		s.versions[v.Key] = v
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	s.file = f
+	return s, nil
+}
+
+func (s *FileHistoryStore) Get(key string) (ConsentVersion, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v, ok := s.versions[key]
+	return v, ok
+}
+
+func (s *FileHistoryStore) Put(v ConsentVersion) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file != nil {
+		line, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		if _, err = s.file.Write(append(line, '\n')); err != nil {
+			return err
+		}
+	}
+
+	s.versions[v.Key] = v
+	return nil
+}
+
+// isHistory returns whether each consent version is kept as a separate Consent resource
+func (m *GicsMapper) isHistory() bool {
+	return m.Config.History.Enabled && m.History != nil
+}
+
+// historyPrevious returns how previous consent versions are marked (default: inactive)
+func (m *GicsMapper) historyPrevious() string {
+	if m.Config.History.Previous != "" {
+		return m.Config.History.Previous
+	}
+	return HistoryInactive
+}
+
+// versionId creates the id of the consent version, which is derived from the template and consent date
+func (m *GicsMapper) versionId(info consentInfo) string {
+	var name, version string
+	if info.template != nil {
+		name, version = deref(info.template.Name), deref(info.template.Version)
+	}
+	return createConsentId(m.Config.ConsentId.Strategy, m.Config.ConsentId,
+		info.domain, info.signerId.Id, name, version, info.date)
+}
+
+// VersionCommit returns a function, which records the new consent version of the mapped bundle in the history,
+// once the bundle has been delivered. The pending version is released right away, so VersionCommit must be called
+// for each mapped bundle, whether it is delivered or not. Returns nil, if the bundle contains no new consent version
+func (m *GicsMapper) VersionCommit(bundle *fhir.Bundle) func() error {
+	v, ok := m.pending.LoadAndDelete(bundle)
+	if !ok {
+		return nil
+	}
+
+	return func() error {
+		if err := m.History.Put(v.(ConsentVersion)); err != nil {
+			return fmt.Errorf("failed to store consent version: %w", err)
+		}
+		return nil
+	}
+}
+
+// versionConsent marks the previous consent version as superseded by the given consent and returns the
+// corresponding patch request and the new version. If the given consent is older than the latest known version,
+// it is marked instead
+func (m *GicsMapper) versionConsent(r fhir.Consent, info consentInfo) (fhir.Consent, *fhir.BundleEntry, *ConsentVersion, error) {
+	current := ConsentVersion{Key: m.consentId(info.domain, info.signerId.Id), Id: *r.Id, Date: info.date}
+
+	prev, ok := m.History.Get(current.Key)
+	if ok && prev.Id == current.Id {
+		return r, nil, nil, nil
+	}
+
+	if ok && isBefore(current.Date, prev.Date) {
+		log.WithFields(log.Fields{"domain": info.domain, "date": current.Date, "latest": prev.Date}).
+			Info("Consent version is older than the latest version")
+
+		if m.historyPrevious() == HistorySuperseded {
+			r.Extension = append(r.Extension, m.supersededExtension(info.domain, prev.Id))
+		} else {
+			r.Status = fhir.ConsentStateInactive
+		}
+		return r, nil, nil, nil
+	}
+
+	if !ok {
+		return r, nil, &current, nil
+	}
+
+	entry, err := m.createPreviousEntry(info.domain, prev.Id, current.Id)
+	return r, entry, &current, err
+}
+
+// createPreviousEntry creates a FHIRPath patch request, which marks the previous consent version
+func (m *GicsMapper) createPreviousEntry(domain, prevId, currentId string) (*fhir.BundleEntry, error) {
+	var operation []fhir.ParametersParameter
+	if m.historyPrevious() == HistorySuperseded {
+		operation = []fhir.ParametersParameter{
+			{Name: "type", ValueCode: Of("add")},
+			{Name: "path", ValueString: Of("Consent")},
+			{Name: "name", ValueString: Of("extension")},
+			{Name: "value", Part: []fhir.ParametersParameter{
+				{Name: "url", ValueUri: Of(supersededExtensionUrl)},
+				{Name: "valueReference", ValueReference: m.supersededExtension(domain, currentId).ValueReference},
+			}},
+		}
+	} else {
+		operation = []fhir.ParametersParameter{
+			{Name: "type", ValueCode: Of("replace")},
+			{Name: "path", ValueString: Of("Consent.status")},
+			{Name: "value", ValueCode: Of(fhir.ConsentStateInactive.Code())},
+		}
+	}
+
+	data, err := fhir.Parameters{
+		Parameter: []fhir.ParametersParameter{{Name: "operation", Part: operation}},
+	}.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	return &fhir.BundleEntry{
+		Resource: data,
+		Request: &fhir.BundleEntryRequest{
+			Method: fhir.HTTPVerbPATCH,
+			Url:    fmt.Sprintf("Consent?identifier=%s|%s", *consentSystemOf(m.Config, domain), prevId),
+		},
+	}, nil
+}
+
+// supersededExtension references the superseding consent version by its identifier
+func (m *GicsMapper) supersededExtension(domain, id string) fhir.Extension {
+	return fhir.Extension{
+		Url: supersededExtensionUrl,
+		ValueReference: &fhir.Reference{
+			Type:       Of("Consent"),
+			Identifier: &fhir.Identifier{System: consentSystemOf(m.Config, domain), Value: Of(id)},
+		},
+	}
+}
+
+// isBefore compares RFC3339 dates. Unparsable dates are considered the latest
+func isBefore(date, other string) bool {
+	d, err1 := time.Parse(time.RFC3339, date)
+	o, err2 := time.Parse(time.RFC3339, other)
+	if err := errors.Join(err1, err2); err != nil {
+		return false
+	}
+	return d.Before(o)
+}
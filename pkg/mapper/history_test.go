@@ -0,0 +1,211 @@
+package mapper
+
+import (
+	"consent-to-fhir/pkg/config"
+	"consent-to-fhir/pkg/model"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+)
+
+func createTestHistoryMapper(previous string) *GicsMapper {
+	m := createTestMapper()
+	m.Config.History = config.History{Enabled: true, Previous: previous}
+	m.History, _ = NewFileHistoryStore("")
+	m.Client = &TestGicsClient{
+		respFilePath: "testdata/current-policies-response.json",
+	}
+	return m
+}
+
+func createTestVersionNotification(date string) model.Notification {
+	n := createTestNotification()
+	n.ConsentKey.ConsentDate = Of(date)
+	return n
+}
+
+// mapDelivered maps the notification and records its consent version, as after delivery
+func mapDelivered(t *testing.T, m *GicsMapper, n model.Notification) *fhir.Bundle {
+	bundle, err := m.Map(context.Background(), n)
+	assert.NoError(t, err)
+
+	if commit := m.VersionCommit(bundle); commit != nil {
+		assert.NoError(t, commit())
+	}
+	return bundle
+}
+
+func TestFileHistoryStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+
+	s, err := NewFileHistoryStore(path)
+	assert.NoError(t, err)
+
+	_, ok := s.Get("a")
+	assert.False(t, ok)
+
+	assert.NoError(t, s.Put(ConsentVersion{Key: "a", Id: "1", Date: "2023-01-01T00:00:00Z"}))
+	assert.NoError(t, s.Put(ConsentVersion{Key: "a", Id: "2", Date: "2024-01-01T00:00:00Z"}))
+	assert.NoError(t, s.Put(ConsentVersion{Key: "b", Id: "3", Date: "2024-01-01T00:00:00Z"}))
+
+	// replay
+	s, err = NewFileHistoryStore(path)
+	assert.NoError(t, err)
+
+	v, ok := s.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, "2", v.Id)
+	v, _ = s.Get("b")
+	assert.Equal(t, "3", v.Id)
+}
+
+func TestProcess_History(t *testing.T) {
+	m := createTestHistoryMapper("")
+
+	first := mapDelivered(t, m, createTestVersionNotification("2023-05-02 01:57:27"))
+	second := mapDelivered(t, m, createTestVersionNotification("2024-05-02 01:57:27"))
+	firstConsent, _ := fhir.UnmarshalConsent(first.Entry[0].Resource)
+	secondConsent, _ := fhir.UnmarshalConsent(second.Entry[0].Resource)
+
+	assert.NotEqual(t, *firstConsent.Id, *secondConsent.Id)
+	assert.Len(t, first.Entry, 2)
+
+	// previous version is patched
+	patch := second.Entry[2]
+	assert.Equal(t, fhir.HTTPVerbPATCH, patch.Request.Method)
+	assert.Equal(t, fmt.Sprintf("Consent?identifier=%s|%s", *m.Config.ConsentSystem, *firstConsent.Id), patch.Request.Url)
+
+	params, _ := fhir.UnmarshalParameters(patch.Resource)
+	assert.Equal(t, "replace", *params.Parameter[0].Part[0].ValueCode)
+	assert.Equal(t, "Consent.status", *params.Parameter[0].Part[1].ValueString)
+	assert.Equal(t, "inactive", *params.Parameter[0].Part[2].ValueCode)
+
+	// same version again
+	again := mapDelivered(t, m, createTestVersionNotification("2024-05-02 01:57:27"))
+	assert.Len(t, again.Entry, 2)
+}
+
+func TestProcess_History_Superseded(t *testing.T) {
+	m := createTestHistoryMapper(HistorySuperseded)
+
+	first := mapDelivered(t, m, createTestVersionNotification("2023-05-02 01:57:27"))
+	second := mapDelivered(t, m, createTestVersionNotification("2024-05-02 01:57:27"))
+	secondConsent, _ := fhir.UnmarshalConsent(second.Entry[0].Resource)
+
+	assert.Len(t, first.Entry, 2)
+	params, _ := fhir.UnmarshalParameters(second.Entry[2].Resource)
+	value := params.Parameter[0].Part[3]
+	assert.Equal(t, supersededExtensionUrl, *value.Part[0].ValueUri)
+	assert.Equal(t, *secondConsent.Id, *value.Part[1].ValueReference.Identifier.Value)
+}
+
+func TestProcess_History_OutOfOrder(t *testing.T) {
+	cases := []struct {
+		previous  string
+		status    fhir.ConsentState
+		extension bool
+	}{
+		{HistoryInactive, fhir.ConsentStateInactive, false},
+		{HistorySuperseded, fhir.ConsentStateActive, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.previous, func(t *testing.T) {
+			m := createTestHistoryMapper(c.previous)
+
+			latest := mapDelivered(t, m, createTestVersionNotification("2024-05-02 01:57:27"))
+			older := mapDelivered(t, m, createTestVersionNotification("2023-05-02 01:57:27"))
+			latestConsent, _ := fhir.UnmarshalConsent(latest.Entry[0].Resource)
+			olderConsent, _ := fhir.UnmarshalConsent(older.Entry[0].Resource)
+
+			assert.Len(t, older.Entry, 2)
+			assert.Equal(t, c.status, olderConsent.Status)
+
+			ext := findExtension(olderConsent.Extension, supersededExtensionUrl)
+			assert.Equal(t, c.extension, ext != nil)
+			if ext != nil {
+				assert.Equal(t, *latestConsent.Id, *ext.ValueReference.Identifier.Value)
+			}
+
+			// latest version is kept
+			v, _ := m.History.Get(m.consentId("MII", "42"))
+			assert.Equal(t, *latestConsent.Id, v.Id)
+		})
+	}
+}
+
+func TestProcess_History_Withdrawal(t *testing.T) {
+	m := createTestHistoryMapper("")
+
+	first := mapDelivered(t, m, createTestVersionNotification("2023-05-02 01:57:27"))
+	firstConsent, _ := fhir.UnmarshalConsent(first.Entry[0].Resource)
+
+	m.Client = &TestGicsClient{
+		respFilePath: "testdata/empty-policies-response.json",
+	}
+	withdrawn := mapDelivered(t, m, createTestVersionNotification("2024-05-02 01:57:27"))
+	withdrawnConsent, _ := fhir.UnmarshalConsent(withdrawn.Entry[0].Resource)
+
+	assert.Equal(t, fhir.ConsentStateInactive, withdrawnConsent.Status)
+	assert.NotEqual(t, *firstConsent.Id, *withdrawnConsent.Id)
+	assert.Equal(t, fmt.Sprintf("Consent?identifier=%s|%s", *m.Config.ConsentSystem, *firstConsent.Id),
+		withdrawn.Entry[2].Request.Url)
+}
+
+func TestProcess_History_NotDelivered(t *testing.T) {
+	m := createTestHistoryMapper("")
+
+	first, _ := m.Map(context.Background(), createTestVersionNotification("2023-05-02 01:57:27"))
+	second := mapDelivered(t, m, createTestVersionNotification("2024-05-02 01:57:27"))
+
+	// first version has not been delivered and is not patched
+	assert.NotNil(t, m.VersionCommit(first))
+	assert.Len(t, second.Entry, 2)
+
+	// released once taken
+	assert.Nil(t, m.VersionCommit(first))
+}
+
+func TestProcess_History_Untracked(t *testing.T) {
+	m := createTestHistoryMapper("")
+	data, _ := json.Marshal(createTestVersionNotification("2023-05-02 01:57:27"))
+
+	bundle := m.Process(context.Background(), data)
+
+	assert.NotNil(t, bundle)
+	assert.Nil(t, m.VersionCommit(bundle))
+}
+
+func TestProcess_History_WithdrawalReplay(t *testing.T) {
+	m := createTestHistoryMapper("")
+	m.Client = &TestGicsClient{
+		respFilePath: "testdata/empty-policies-response.json",
+	}
+
+	withdrawn := mapDelivered(t, m, createTestVersionNotification("2024-05-02 01:57:27"))
+	replayed := mapDelivered(t, m, createTestVersionNotification("2024-05-02 01:57:27"))
+	withdrawnConsent, _ := fhir.UnmarshalConsent(withdrawn.Entry[0].Resource)
+	replayedConsent, _ := fhir.UnmarshalConsent(replayed.Entry[0].Resource)
+
+	assert.Equal(t, *withdrawnConsent.Id, *replayedConsent.Id)
+	assert.Len(t, replayed.Entry, 2)
+}
+
+func findExtension(extensions []fhir.Extension, url string) *fhir.Extension {
+	for _, e := range extensions {
+		if e.Url == url {
+			return &e
+		}
+	}
+	return nil
+}
+
+func TestIsBefore(t *testing.T) {
+	assert.True(t, isBefore("2023-01-01T00:00:00Z", "2024-01-01T00:00:00+01:00"))
+	assert.False(t, isBefore("2024-01-01T00:00:00Z", "2023-01-01T00:00:00Z"))
+	assert.False(t, isBefore("2023-01-01", "2024-01-01T00:00:00Z"))
+}
@@ -0,0 +1,31 @@
+package mapper
+
+import (
+	"context"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+)
+
+// resolveSigner replaces the primary signer id with its pseudonym, if an identity resolver is configured. The
+// pseudonym's system is used as patient identifier system, if present. Additional signer ids are not pseudonymized
+// and thus dropped
+func (m *GicsMapper) resolveSigner(ctx context.Context, info consentInfo) (consentInfo, error) {
+	if m.Resolver == nil {
+		return info, nil
+	}
+
+	psn, err := m.Resolver.Resolve(ctx, info.signerId)
+	if err != nil {
+		return info, fmt.Errorf("failed to resolve signer id of type '%s': %w", info.signerId.IdType, err)
+	}
+
+	log.WithFields(log.Fields{"domain": info.domain, "pseudonym": *psn.Value}).Debug("Resolved signer id")
+
+	info.signerId.Id = *psn.Value
+	info.others = nil
+	if psn.System != nil && *psn.System != "" {
+		info.patientSystem = *psn.System
+	}
+
+	return info, nil
+}
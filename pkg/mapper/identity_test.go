@@ -0,0 +1,82 @@
+package mapper
+
+import (
+	"consent-to-fhir/pkg/client"
+	"consent-to-fhir/pkg/config"
+	"consent-to-fhir/pkg/model"
+	"context"
+	"github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestProcess_ResolveSigner(t *testing.T) {
+	cases := []struct {
+		name            string
+		system          string
+		expectedPatient string
+	}{
+		{
+			name:            "pseudonym system",
+			system:          "https://fhir.diz.uni-marburg.de/sid/patient-psn",
+			expectedPatient: "Patient?identifier=https://fhir.diz.uni-marburg.de/sid/patient-psn|psn-42",
+		},
+		{
+			name:            "patient system",
+			expectedPatient: "Patient?identifier=https://fhir.diz.uni-marburg.de/sid/patient-id|psn-42",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			m := createTestMapper()
+			m.Client = &TestGicsClient{
+				respFilePath: "testdata/current-policies-response.json",
+			}
+			m.Resolver = &client.MemoryResolver{Pseudonyms: map[string]string{"42": "psn-42"}, System: c.system}
+
+			bundle, err := m.Map(context.Background(), createTestNotification())
+			consent, _ := fhir.UnmarshalConsent(bundle.Entry[0].Resource)
+
+			assert.NoError(t, err)
+			assert.Equal(t, c.expectedPatient, *consent.Patient.Reference)
+			assert.Equal(t, hash("MII", "psn-42"), *consent.Id)
+		})
+	}
+}
+
+func TestProcess_ResolveSigner_OtherSignerIds(t *testing.T) {
+	m := createTestMapper()
+	m.Config.Domains = map[string]config.Domain{"MII": {PatientStub: true}}
+	m.Client = &TestGicsClient{
+		respFilePath: "testdata/current-policies-response.json",
+	}
+	m.Resolver = &client.MemoryResolver{Pseudonyms: map[string]string{"42": "psn-42"}}
+	n := createTestNotification()
+	n.ConsentKey.SignerIds = append(n.ConsentKey.SignerIds,
+		model.SignerId{IdType: "Fallnummer", Id: "case-7", OrderNumber: Of(1)})
+
+	bundle, err := m.Map(context.Background(), n)
+	assert.NoError(t, err)
+	consent, _ := fhir.UnmarshalConsent(bundle.Entry[0].Resource)
+	patient, _ := fhir.UnmarshalPatient(bundle.Entry[len(bundle.Entry)-1].Resource)
+
+	assert.Len(t, consent.Identifier, 1)
+	assert.Len(t, patient.Identifier, 1)
+	assert.Equal(t, "psn-42", *patient.Identifier[0].Value)
+	for _, e := range bundle.Entry {
+		assert.NotContains(t, string(e.Resource), "case-7")
+	}
+}
+
+func TestProcess_ResolveSigner_Unknown(t *testing.T) {
+	m := createTestMapper()
+	m.Client = &TestGicsClient{
+		respFilePath: "testdata/current-policies-response.json",
+	}
+	m.Resolver = &client.MemoryResolver{Pseudonyms: map[string]string{}}
+
+	_, err := m.Map(context.Background(), createTestNotification())
+
+	assert.ErrorIs(t, err, client.ErrUnknownIdentity)
+}
@@ -0,0 +1,180 @@
+package mapper
+
+import (
+	"consent-to-fhir/pkg/config"
+	"consent-to-fhir/pkg/model"
+	"errors"
+	"fmt"
+	"github.com/samply/golang-fhir-models/fhir-models/fhir"
+	log "github.com/sirupsen/logrus"
+	"strings"
+)
+
+const (
+	MiiProfile = "https://www.medizininformatik-initiative.de/fhir/modul-consent/StructureDefinition/mii-pr-consent-einwilligung"
+
+	miiTemplateName         = "Patienteneinwilligung MII"
+	miiProvisionSystem      = "urn:oid:2.16.840.1.113883.3.1937.777.24.5.3"
+	miiPolicySystem         = "https://www.medizininformatik-initiative.de/fhir/modul-consent/CodeSystem/mii-cs-consent-policy"
+	miiCategorySystem       = "https://www.medizininformatik-initiative.de/fhir/modul-consent/CodeSystem/mii-cs-consent-consent_category"
+	miiBroadConsentCategory = "2.16.840.1.113883.3.1937.777.24.2.184"
+)
+
+// ErrUnknownTemplate signals, that no MII template configuration matches the signed consent template and strict
+// template matching is enabled
+var ErrUnknownTemplate = errors.New("unknown MII consent template")
+
+// miiTemplates are the built-in MII Broad Consent template versions
+var miiTemplates = []config.Template{
+	{
+		Name:      miiTemplateName,
+		Version:   "1.6.d",
+		PolicyUri: "urn:oid:2.16.840.1.113883.3.1937.777.24.2.1790",
+		Categories: []config.Coding{
+			{System: "http://loinc.org", Code: "57016-8"},
+			{System: miiCategorySystem, Code: miiBroadConsentCategory},
+		},
+		ProvisionSystem: miiProvisionSystem,
+	},
+	{
+		Name:      miiTemplateName,
+		Version:   "1.7",
+		PolicyUri: "urn:oid:2.16.840.1.113883.3.1937.777.24.2.1791",
+		Categories: []config.Coding{
+			{System: "http://loinc.org", Code: "57016-8"},
+			{System: miiCategorySystem, Code: miiBroadConsentCategory},
+		},
+		ProvisionSystem: miiProvisionSystem,
+	},
+	{
+		Name:      miiTemplateName,
+		Version:   "2025",
+		PolicyUri: "urn:oid:2.16.840.1.113883.3.1937.777.24.2.3542",
+		Categories: []config.Coding{
+			{System: "http://loinc.org", Code: "59284-0"},
+			{System: miiCategorySystem, Code: miiBroadConsentCategory},
+		},
+		ProvisionSystem: miiPolicySystem,
+	},
+}
+
+// MiiProfileMapper maps consents to the MII Broad Consent profile depending on the
+// signed consent template version. Unknown template versions are mapped as 1.6.d, unless Strict is set
+type MiiProfileMapper struct {
+	Templates []config.Template
+	Strict    bool
+}
+
+// NewMiiProfileMapper creates a MiiProfileMapper with the built-in template versions. Additional templates take
+// precedence over built-in ones
+func NewMiiProfileMapper(templates []config.Template, strict bool) *MiiProfileMapper {
+	all := make([]config.Template, 0, len(templates)+len(miiTemplates))
+	return &MiiProfileMapper{Templates: append(append(all, templates...), miiTemplates...), Strict: strict}
+}
+
+// Check returns ErrUnknownTemplate, if no template configuration matches the template name and version and
+// Strict is set. Otherwise, the fallback to 1.6.d is logged
+func (m *MiiProfileMapper) Check(template *model.ConsentTemplateKey) error {
+	if template == nil || template.Version == nil || m.matchTemplate(template) != nil {
+		return nil
+	}
+
+	if m.Strict {
+		return fmt.Errorf("%w '%s' version '%s': configure it via app.mapper.templates", ErrUnknownTemplate,
+			deref(template.Name), deref(template.Version))
+	}
+	log.WithFields(log.Fields{"name": deref(template.Name), "version": *template.Version}).
+		Warn("No MII consent template configuration found for template version, mapping as 1.6.d")
+	return nil
+}
+
+func (m *MiiProfileMapper) Category(consent fhir.Consent, template *model.ConsentTemplateKey) []fhir.CodeableConcept {
+	t := m.getTemplate(template)
+	if t == nil || len(t.Categories) == 0 {
+		return consent.Category
+	}
+
+	var categories []fhir.CodeableConcept
+	for _, c := range t.Categories {
+		coding := fhir.Coding{System: Of(c.System), Code: Of(c.Code)}
+		if c.Display != "" {
+			coding.Display = Of(c.Display)
+		}
+		categories = append(categories, fhir.CodeableConcept{Coding: []fhir.Coding{coding}})
+	}
+	return categories
+}
+
+func (m *MiiProfileMapper) Policy(_ fhir.Consent, template *model.ConsentTemplateKey) []fhir.ConsentPolicy {
+	t := m.getTemplate(template)
+	if t == nil || t.PolicyUri == "" {
+		return nil
+	}
+
+	return []fhir.ConsentPolicy{
+		{
+			// e.g. Patienteneinwilligung MII|1.6.d
+			Uri: Of(t.PolicyUri),
+		},
+	}
+}
+
+func (m *MiiProfileMapper) Provision(provision *fhir.ConsentProvision, template *model.ConsentTemplateKey) *fhir.ConsentProvision {
+	t := m.getTemplate(template)
+	if provision == nil || t == nil || t.ProvisionSystem == "" || t.ProvisionSystem == miiProvisionSystem {
+		return provision
+	}
+
+	// rewrite provision code system
+	for i := range provision.Provision {
+		for j := range provision.Provision[i].Code {
+			codings := provision.Provision[i].Code[j].Coding
+			for k := range codings {
+				if codings[k].System != nil && *codings[k].System == miiProvisionSystem {
+					codings[k].System = Of(t.ProvisionSystem)
+				}
+			}
+		}
+	}
+
+	return provision
+}
+
+func (m *MiiProfileMapper) Extension(extensions []fhir.Extension, _ *model.ConsentTemplateKey) []fhir.Extension {
+	return extensions
+}
+
+// getTemplate returns the best matching template configuration or 1.6.d, if none matches and Strict is not set
+func (m *MiiProfileMapper) getTemplate(template *model.ConsentTemplateKey) *config.Template {
+	if template == nil || template.Version == nil {
+		// unknown, default to 1.6.d
+		return &miiTemplates[0]
+	}
+
+	match := m.matchTemplate(template)
+	if match == nil && !m.Strict {
+		return &miiTemplates[0]
+	}
+	return match
+}
+
+// matchTemplate returns the best matching template configuration, by name and version. A template version matches
+// the exact version or any version with the configured prefix (e.g. "1.7" matches "1.7.2")
+func (m *MiiProfileMapper) matchTemplate(template *model.ConsentTemplateKey) *config.Template {
+	name := deref(template.Name)
+
+	var match *config.Template
+	for i, t := range m.Templates {
+		if t.Name != "" && t.Name != name {
+			continue
+		}
+		if t.Version != *template.Version && !strings.HasPrefix(*template.Version, t.Version+".") {
+			continue
+		}
+		if match == nil || len(t.Version) > len(match.Version) {
+			match = &m.Templates[i]
+		}
+	}
+
+	return match
+}
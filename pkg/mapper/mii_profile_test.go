@@ -0,0 +1,205 @@
+package mapper
+
+import (
+	"consent-to-fhir/pkg/config"
+	"consent-to-fhir/pkg/model"
+	"context"
+	"github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestMiiProfileMapper(t *testing.T) {
+	m := NewMiiProfileMapper([]config.Template{
+		{
+			Name:       miiTemplateName,
+			Version:    "1.7",
+			PolicyUri:  "urn:oid:1.2.3.4.17",
+			Categories: []config.Coding{{System: "http://loinc.org", Code: "57016-8"}},
+			ProvisionSystem: "https://www.medizininformatik-initiative.de/fhir/modul-consent/CodeSystem/" +
+				"mii-cs-consent-policy",
+		},
+		{
+			Name:      miiTemplateName,
+			Version:   "1.7.3",
+			PolicyUri: "urn:oid:1.2.3.4.173",
+		},
+	}, false)
+
+	cases := []struct {
+		name              string
+		template          *model.ConsentTemplateKey
+		expectedPolicy    []fhir.ConsentPolicy
+		expectedCategory  int
+		expectedProvision string
+	}{
+		{
+			name:              "unknownTemplate",
+			template:          nil,
+			expectedPolicy:    []fhir.ConsentPolicy{{Uri: Of("urn:oid:2.16.840.1.113883.3.1937.777.24.2.1790")}},
+			expectedCategory:  2,
+			expectedProvision: miiProvisionSystem,
+		},
+		{
+			name:              "builtIn",
+			template:          &model.ConsentTemplateKey{Name: Of(miiTemplateName), Version: Of("1.6.d")},
+			expectedPolicy:    []fhir.ConsentPolicy{{Uri: Of("urn:oid:2.16.840.1.113883.3.1937.777.24.2.1790")}},
+			expectedCategory:  2,
+			expectedProvision: miiProvisionSystem,
+		},
+		{
+			name:              "versionPrefix",
+			template:          &model.ConsentTemplateKey{Name: Of(miiTemplateName), Version: Of("1.7.2")},
+			expectedPolicy:    []fhir.ConsentPolicy{{Uri: Of("urn:oid:1.2.3.4.17")}},
+			expectedCategory:  1,
+			expectedProvision: "https://www.medizininformatik-initiative.de/fhir/modul-consent/CodeSystem/mii-cs-consent-policy",
+		},
+		{
+			name:              "mostSpecificVersion",
+			template:          &model.ConsentTemplateKey{Name: Of(miiTemplateName), Version: Of("1.7.3")},
+			expectedPolicy:    []fhir.ConsentPolicy{{Uri: Of("urn:oid:1.2.3.4.173")}},
+			expectedCategory:  1,
+			expectedProvision: miiProvisionSystem,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			consent := fhir.Consent{
+				Meta:     &fhir.Meta{Profile: []string{MiiProfile}},
+				Category: []fhir.CodeableConcept{{Coding: []fhir.Coding{{System: Of("http://loinc.org"), Code: Of("57016-8")}}}},
+				Provision: &fhir.ConsentProvision{
+					Type: Of(fhir.ConsentProvisionTypeDeny),
+					Provision: []fhir.ConsentProvision{{
+						Type: Of(fhir.ConsentProvisionTypePermit),
+						Code: []fhir.CodeableConcept{{Coding: []fhir.Coding{{
+							System: Of(miiProvisionSystem),
+							Code:   Of("2.16.840.1.113883.3.1937.777.24.5.3.2"),
+						}}}},
+					}},
+				},
+			}
+
+			actual := fhir.Consent{
+				Category:  m.Category(consent, c.template),
+				Policy:    m.Policy(consent, c.template),
+				Provision: m.Provision(consent.Provision, c.template),
+			}
+
+			assert.Equal(t, c.expectedPolicy, actual.Policy)
+			assert.Len(t, actual.Category, c.expectedCategory)
+			assert.Equal(t, c.expectedProvision, *actual.Provision.Provision[0].Code[0].Coding[0].System)
+		})
+	}
+}
+
+func TestMiiProfileMapper_BuiltIn(t *testing.T) {
+	m := NewMiiProfileMapper(nil, false)
+
+	cases := []struct {
+		name              string
+		version           string
+		expectedPolicy    string
+		expectedCategory  string
+		expectedProvision string
+	}{
+		{"1.6.d", "1.6.d", "urn:oid:2.16.840.1.113883.3.1937.777.24.2.1790", "57016-8", miiProvisionSystem},
+		{"1.7", "1.7.2", "urn:oid:2.16.840.1.113883.3.1937.777.24.2.1791", "57016-8", miiProvisionSystem},
+		{"2025", "2025.0.1", "urn:oid:2.16.840.1.113883.3.1937.777.24.2.3542", "59284-0", miiPolicySystem},
+		{"fallback", "1.6.f", "urn:oid:2.16.840.1.113883.3.1937.777.24.2.1790", "57016-8", miiProvisionSystem},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			template := &model.ConsentTemplateKey{Name: Of(miiTemplateName), Version: Of(c.version)}
+			provision := &fhir.ConsentProvision{Provision: []fhir.ConsentProvision{{
+				Code: []fhir.CodeableConcept{{Coding: []fhir.Coding{{System: Of(miiProvisionSystem), Code: Of("2.16.840.1.113883.3.1937.777.24.5.3.2")}}}},
+			}}}
+
+			assert.NoError(t, m.Check(template))
+			assert.Equal(t, []fhir.ConsentPolicy{{Uri: Of(c.expectedPolicy)}}, m.Policy(fhir.Consent{}, template))
+			assert.Equal(t, c.expectedCategory, *m.Category(fhir.Consent{}, template)[0].Coding[0].Code)
+			assert.Equal(t, c.expectedProvision, *m.Provision(provision, template).Provision[0].Code[0].Coding[0].System)
+		})
+	}
+}
+
+func TestMiiProfileMapper_Check(t *testing.T) {
+	templates := []config.Template{{Name: miiTemplateName, Version: "1.8", PolicyUri: "urn:oid:1.2.3.4.18"}}
+
+	cases := []struct {
+		name     string
+		strict   bool
+		template *model.ConsentTemplateKey
+		expected error
+	}{
+		{"unknownTemplate", true, nil, nil},
+		{"builtIn", true, &model.ConsentTemplateKey{Name: Of(miiTemplateName), Version: Of("1.7.2")}, nil},
+		{"configured", true, &model.ConsentTemplateKey{Name: Of(miiTemplateName), Version: Of("1.8.1")}, nil},
+		{"unknownVersion", true, &model.ConsentTemplateKey{Name: Of(miiTemplateName), Version: Of("1.6.f")}, ErrUnknownTemplate},
+		{"unmatchedName", true, &model.ConsentTemplateKey{Name: Of("Other"), Version: Of("1.6.d")}, ErrUnknownTemplate},
+		{"fallback", false, &model.ConsentTemplateKey{Name: Of(miiTemplateName), Version: Of("1.6.f")}, nil},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := NewMiiProfileMapper(templates, c.strict).Check(c.template)
+
+			if c.expected == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, c.expected)
+			}
+		})
+	}
+}
+
+func TestMap_Templates(t *testing.T) {
+	m := createTestMapper()
+	m.Client = &TestGicsClient{respFilePath: "testdata/current-policies-response.json"}
+	local := config.Template{Name: "Einwilligung Forschung", Version: "1.8", PolicyUri: "urn:oid:1.2.3.4.18"}
+
+	cases := []struct {
+		name              string
+		strict            bool
+		templateName      string
+		version           string
+		expectedPolicy    string
+		expectedProvision string
+		expectedErr       error
+	}{
+		{"1.6.d", true, miiTemplateName, "1.6.d", "urn:oid:2.16.840.1.113883.3.1937.777.24.2.1790", miiProvisionSystem, nil},
+		{"1.7", true, miiTemplateName, "1.7.2", "urn:oid:2.16.840.1.113883.3.1937.777.24.2.1791", miiProvisionSystem, nil},
+		{"2025", true, miiTemplateName, "2025.0.1", "urn:oid:2.16.840.1.113883.3.1937.777.24.2.3542", miiPolicySystem, nil},
+		{"configured", true, local.Name, "1.8.1", "urn:oid:1.2.3.4.18", miiProvisionSystem, nil},
+		{"fallback", false, miiTemplateName, "1.6.f", "urn:oid:2.16.840.1.113883.3.1937.777.24.2.1790", miiProvisionSystem, nil},
+		{"strict", true, miiTemplateName, "1.6.f", "", "", ErrUnknownTemplate},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			m.Profiles = map[string]ProfileMapper{MiiProfile: NewMiiProfileMapper([]config.Template{local}, c.strict)}
+			n := createTestNotification()
+			n.ConsentKey.ConsentTemplateKey.Name = Of(c.templateName)
+			n.ConsentKey.ConsentTemplateKey.Version = Of(c.version)
+
+			actual, err := m.Map(context.Background(), n)
+
+			if c.expectedErr != nil {
+				assert.ErrorIs(t, err, c.expectedErr)
+				assert.Nil(t, actual)
+				return
+			}
+			assert.NoError(t, err)
+			consent, _ := fhir.UnmarshalConsent(actual.Entry[0].Resource)
+			assert.Equal(t, []fhir.ConsentPolicy{{Uri: Of(c.expectedPolicy)}}, consent.Policy)
+			assert.Equal(t, c.expectedProvision, *consent.Provision.Provision[0].Code[0].Coding[0].System)
+		})
+	}
+
+	t.Run("registryUnchanged", func(t *testing.T) {
+		registered, _ := GetProfileMapper(MiiProfile)
+
+		assert.Nil(t, registered.(*MiiProfileMapper).matchTemplate(&model.ConsentTemplateKey{Name: Of(local.Name), Version: Of("1.8.1")}))
+	})
+}
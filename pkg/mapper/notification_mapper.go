@@ -0,0 +1,160 @@
+package mapper
+
+import (
+	"consent-to-fhir/pkg/config"
+	"consent-to-fhir/pkg/model"
+	"errors"
+	"fmt"
+	"github.com/samply/golang-fhir-models/fhir-models/fhir"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+const (
+	GicsMode         = "gics"
+	NotificationMode = "notification"
+
+	gicsPolicySystem         = "https://ths-greifswald.de/fhir/CodeSystem/gics/Policy"
+	consentManagementProfile = "http://fhir.de/ConsentManagement/StructureDefinition/Consent"
+	domainReferenceUrl       = "http://fhir.de/ConsentManagement/StructureDefinition/DomainReference"
+)
+
+// NotificationMapper builds consent resources straight from the policy states of a gICS notification,
+// without requesting them from the TTP-FHIR gateway
+type NotificationMapper struct {
+	Config  config.Mapper
+	CodeMap *PolicyCodeMap
+}
+
+func NewNotificationMapper(c config.Mapper, codeMap *PolicyCodeMap) *NotificationMapper {
+	return &NotificationMapper{Config: c, CodeMap: codeMap}
+}
+
+// GetConsentStatus creates a bundle of Consent resources (one per policy) equivalent to the
+// $currentPolicyStatesForPerson response of the TTP-FHIR gateway
+func (m *NotificationMapper) GetConsentStatus(n model.Notification) (*fhir.Bundle, error) {
+	key := n.ConsentKey
+	if key == nil || key.ConsentTemplateKey == nil || key.ConsentTemplateKey.DomainName == nil || key.ConsentDate == nil {
+		return nil, errors.New("notification is missing consent key data")
+	}
+	domain := *key.ConsentTemplateKey.DomainName
+
+	date, err := parseConsentDate(*key.ConsentDate)
+	if err != nil {
+		return nil, err
+	}
+
+	// policy codes may be mapped by ConceptMaps later on
+	profile, _ := profileOf(m.Config, domain)
+	useCodeMap := m.CodeMap.Applies(domain, profile)
+
+	var entries []fhir.BundleEntry
+	permitted := false
+	for _, s := range n.CurrentPolicyStates {
+		if s.Key == nil || s.Key.Name == nil || (s.Key.DomainName != nil && *s.Key.DomainName != domain) {
+			continue
+		}
+
+		coding := m.mapPolicy(domain, *s.Key)
+		if coding == nil && !useCodeMap {
+			log.WithFields(log.Fields{"domain": domain, "policy": *s.Key.Name}).
+				Warn("No coding configured for policy. Skipping")
+			continue
+		}
+
+		res, err := m.createConsent(domain, date, *s.Key.Name, coding, s.Value).MarshalJSON()
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, fhir.BundleEntry{Resource: res})
+		permitted = permitted || s.Value
+	}
+
+	// no permitted policy indicates invalidation, like an empty gICS response
+	if !permitted {
+		entries = nil
+	}
+
+	return &fhir.Bundle{
+		Type:  fhir.BundleTypeCollection,
+		Entry: entries,
+	}, nil
+}
+
+// GetConsentDomain creates a ResearchStudy resource for the consent domain
+func (m *NotificationMapper) GetConsentDomain(domain string) *fhir.ResearchStudy {
+	return &fhir.ResearchStudy{
+		Title:  &domain,
+		Status: fhir.ResearchStudyStatusActive,
+	}
+}
+
+func (m *NotificationMapper) mapPolicy(domain string, key model.PolicyStateKey) *fhir.Coding {
+	for _, p := range m.Config.Domains[domain].Policies {
+		if p.Name != *key.Name || (p.Version != "" && (key.Version == nil || p.Version != *key.Version)) {
+			continue
+		}
+
+		coding := &fhir.Coding{System: &p.System, Code: &p.Code}
+		if p.Display != "" {
+			coding.Display = &p.Display
+		}
+		return coding
+	}
+
+	return nil
+}
+
+func (m *NotificationMapper) createConsent(domain, date, policy string, coding *fhir.Coding, permit bool) fhir.Consent {
+	provisionType := fhir.ConsentProvisionTypeDeny
+	if permit {
+		provisionType = fhir.ConsentProvisionTypePermit
+	}
+	codings := []fhir.Coding{{System: Of(gicsPolicySystem), Code: &policy}}
+	if coding != nil {
+		codings = append(codings, *coding)
+	}
+
+	c := newConsent(domain, fhir.ConsentStateActive, date)
+	c.Provision.Provision = []fhir.ConsentProvision{{
+		Type:   &provisionType,
+		Period: &fhir.Period{Start: &date},
+		Code:   []fhir.CodeableConcept{{Coding: codings}},
+	}}
+
+	return c
+}
+
+// newConsent creates a Consent resource with a top-level deny provision, like the ones provided by gICS
+func newConsent(domain string, status fhir.ConsentState, date string) fhir.Consent {
+	return fhir.Consent{
+		Meta: &fhir.Meta{Profile: []string{consentManagementProfile}},
+		Extension: []fhir.Extension{{
+			Url: domainReferenceUrl,
+			Extension: []fhir.Extension{{
+				Url:            "domain",
+				ValueReference: &fhir.Reference{Display: &domain},
+			}},
+		}},
+		Status: status,
+		Scope: fhir.CodeableConcept{
+			Coding: []fhir.Coding{{System: Of("http://terminology.hl7.org/CodeSystem/consentscope"), Code: Of("research")}},
+		},
+		Category: []fhir.CodeableConcept{{
+			Coding: []fhir.Coding{{System: Of("http://loinc.org"), Code: Of("57016-8")}},
+		}},
+		DateTime: &date,
+		Provision: &fhir.ConsentProvision{
+			Type:   Of(fhir.ConsentProvisionTypeDeny),
+			Period: &fhir.Period{Start: &date},
+		},
+	}
+}
+
+func parseConsentDate(date string) (string, error) {
+	t, err := time.ParseInLocation(time.DateTime, date, time.Local)
+	if err != nil {
+		return "", fmt.Errorf("unable to parse consent date '%s': %w", date, err)
+	}
+	return t.Format(time.RFC3339), nil
+}
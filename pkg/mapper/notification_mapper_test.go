@@ -0,0 +1,175 @@
+package mapper
+
+import (
+	"consent-to-fhir/pkg/config"
+	"consent-to-fhir/pkg/model"
+	"context"
+	"github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func createTestNotificationMapperConfig() config.Mapper {
+	return config.Mapper{
+		ConsentSystem: Of("https://fhir.diz.uni-marburg.de/sid/consent-id"),
+		PatientSystem: Of("https://fhir.diz.uni-marburg.de/sid/patient-id"),
+		DomainSystem:  Of("https://fhir.diz.uni-marburg.de/fhir/sid/consent-domain-id"),
+		Profiles: map[string]string{
+			"MII": MiiProfile,
+		},
+		Domains: map[string]config.Domain{
+			"MII": {
+				Mode: NotificationMode,
+				Policies: []config.PolicyMapping{
+					{
+						Name:    "IDAT_erheben",
+						Version: "1.0",
+						System:  "urn:oid:2.16.840.1.113883.3.1937.777.24.5.3",
+						Code:    "2.16.840.1.113883.3.1937.777.24.5.3.2",
+						Display: "IDAT_erheben",
+					},
+					{
+						Name:   "MDAT_erheben",
+						System: "urn:oid:2.16.840.1.113883.3.1937.777.24.5.3",
+						Code:   "2.16.840.1.113883.3.1937.777.24.5.3.6",
+					},
+				},
+			},
+		},
+	}
+}
+
+func createTestNotification(states ...model.PolicyState) model.Notification {
+	return model.Notification{
+		ConsentKey: &model.ConsentKey{
+			ConsentTemplateKey: &model.ConsentTemplateKey{
+				DomainName: Of("MII"),
+				Name:       Of("Patienteneinwilligung MII"),
+				Version:    Of("1.6.d"),
+			},
+			SignerIds:   []model.SignerId{{IdType: "Patienten-ID", Id: "42"}},
+			ConsentDate: Of("2023-05-02 01:57:27"),
+		},
+		CurrentPolicyStates: states,
+	}
+}
+
+func policyState(name, version string, value bool) model.PolicyState {
+	return model.PolicyState{
+		Key:   &model.PolicyStateKey{DomainName: Of("MII"), Name: &name, Version: &version},
+		Value: value,
+	}
+}
+
+func TestNotificationMapper_GetConsentStatus(t *testing.T) {
+
+	cases := []struct {
+		name     string
+		states   []model.PolicyState
+		expected []string
+	}{
+		{
+			name: "mapped",
+			states: []model.PolicyState{
+				policyState("IDAT_erheben", "1.0", true),
+				policyState("MDAT_erheben", "1.1", false),
+			},
+			expected: []string{"2.16.840.1.113883.3.1937.777.24.5.3.2", "2.16.840.1.113883.3.1937.777.24.5.3.6"},
+		},
+		{
+			name: "versionMismatch",
+			states: []model.PolicyState{
+				policyState("IDAT_erheben", "2.0", true),
+				policyState("MDAT_erheben", "1.1", true),
+			},
+			expected: []string{"2.16.840.1.113883.3.1937.777.24.5.3.6"},
+		},
+		{
+			name: "unmappedSkipped",
+			states: []model.PolicyState{
+				policyState("IDAT_erheben", "1.0", true),
+				policyState("Rekontaktierung_Zusatzbefund", "1.0", true),
+			},
+			expected: []string{"2.16.840.1.113883.3.1937.777.24.5.3.2"},
+		},
+		{
+			name: "noPermitIsEmpty",
+			states: []model.PolicyState{
+				policyState("IDAT_erheben", "1.0", false),
+				policyState("MDAT_erheben", "1.1", false),
+			},
+			expected: nil,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			m := NewNotificationMapper(createTestNotificationMapperConfig(), nil)
+
+			bundle, err := m.GetConsentStatus(createTestNotification(c.states...))
+
+			var actual []string
+			for _, e := range bundle.Entry {
+				consent, _ := fhir.UnmarshalConsent(e.Resource)
+				codings := consent.Provision.Provision[0].Code[0].Coding
+				actual = append(actual, *codings[len(codings)-1].Code)
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, c.expected, actual)
+		})
+	}
+}
+
+func TestNotificationMapper_GetConsentStatus_InvalidDate(t *testing.T) {
+	m := NewNotificationMapper(createTestNotificationMapperConfig(), nil)
+	n := createTestNotification(policyState("IDAT_erheben", "1.0", true))
+	n.ConsentKey.ConsentDate = Of("02.05.2023")
+
+	_, err := m.GetConsentStatus(n)
+
+	assert.Error(t, err)
+}
+
+func TestProcess_NotificationMode(t *testing.T) {
+	m := NewGicsMapper(config.AppConfig{App: config.App{Mapper: createTestNotificationMapperConfig()}})
+	m.Client = nil
+
+	input := []byte(`
+		{
+		  "consentKey": {
+			"consentTemplateKey": {
+			  "domainName": "MII",
+			  "name": "Patienteneinwilligung MII",
+			  "version": "1.6.d"
+			},
+			"signerIds": [
+			  {
+				"idType": "Patienten-ID",
+				"id": "42",
+				"orderNumber": 0
+			  }
+			],
+			"consentDate": "2023-05-02 01:57:27"
+		  },
+		  "currentPolicyStates": [
+			{
+			  "key": {
+				"domainName": "MII",
+				"name": "IDAT_erheben",
+				"version": "1.0"
+			  },
+			  "value": true
+			}
+		  ]
+		}
+	`)
+
+	bundle := m.Process(context.Background(), input)
+	consent, _ := fhir.UnmarshalConsent(bundle.Entry[0].Resource)
+	study, _ := fhir.UnmarshalResearchStudy(bundle.Entry[1].Resource)
+
+	assert.Equal(t, []string{MiiProfile}, consent.Meta.Profile)
+	assert.Equal(t, "2.16.840.1.113883.3.1937.777.24.5.3.2", *consent.Provision.Provision[0].Code[0].Coding[0].Code)
+	assert.Equal(t, "MII", *study.Title)
+}
@@ -0,0 +1,50 @@
+package mapper
+
+import (
+	"fmt"
+	"github.com/samply/golang-fhir-models/fhir-models/fhir"
+)
+
+// patientStub returns whether a Patient resource should be created, if it does not exist yet
+func (m *GicsMapper) patientStub(domain string) bool {
+	return m.Config.Domains[domain].PatientStub
+}
+
+// patientReference returns the Consent's patient reference. This is either a conditional reference by identifier or
+// the full url of the Patient stub within the transaction
+func (m *GicsMapper) patientReference(info consentInfo) string {
+	if m.patientStub(info.domain) {
+		return "urn:uuid:" + nameUuid(info.patientSystem, info.signerId.Id)
+	}
+	return fmt.Sprintf("Patient?identifier=%s|%s", info.patientSystem, info.signerId.Id)
+}
+
+// createPatientEntry creates a conditional create request for a Patient resource with the signer's identifiers
+func (m *GicsMapper) createPatientEntry(info consentInfo) (*fhir.BundleEntry, error) {
+	pid := info.signerId.Id
+
+	data, err := fhir.Patient{
+		Identifier: append([]fhir.Identifier{{
+			System: &info.patientSystem,
+			Value:  &pid,
+		}}, signerIdentifiers(info.others, m.Config.Domains[info.domain].Signer)...),
+	}.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	return &fhir.BundleEntry{
+		FullUrl:  Of(m.patientReference(info)),
+		Resource: data,
+		Request: &fhir.BundleEntryRequest{
+			Method:      fhir.HTTPVerbPOST,
+			IfNoneExist: Of(fmt.Sprintf("identifier=%s|%s", info.patientSystem, pid)),
+			Url:         "Patient",
+		},
+	}, nil
+}
+
+// nameUuid creates a stable name-based UUID from the given values
+func nameUuid(values ...string) string {
+	return uuid5(make([]byte, 16), encodeValues(values...))
+}
@@ -0,0 +1,50 @@
+package mapper
+
+import (
+	"consent-to-fhir/pkg/config"
+	"context"
+	"github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestProcess_PatientStub(t *testing.T) {
+	m := createTestMapper()
+	m.Config.Domains = map[string]config.Domain{"MII": {PatientStub: true}}
+	m.Client = &TestGicsClient{
+		respFilePath: "testdata/current-policies-response.json",
+	}
+
+	bundle, _ := m.Map(context.Background(), createTestNotification())
+	consent, _ := fhir.UnmarshalConsent(bundle.Entry[0].Resource)
+	patientEntry := bundle.Entry[2]
+	patient, _ := fhir.UnmarshalPatient(patientEntry.Resource)
+
+	assert.True(t, strings.HasPrefix(*consent.Patient.Reference, "urn:uuid:"))
+	assert.Equal(t, *consent.Patient.Reference, *patientEntry.FullUrl)
+	assert.Equal(t, fhir.HTTPVerbPOST, patientEntry.Request.Method)
+	assert.Equal(t, "identifier=https://fhir.diz.uni-marburg.de/sid/patient-id|42", *patientEntry.Request.IfNoneExist)
+	assert.Equal(t, "42", *patient.Identifier[0].Value)
+}
+
+func TestProcess_NoPatientStub(t *testing.T) {
+	m := createTestMapper()
+	m.Client = &TestGicsClient{
+		respFilePath: "testdata/current-policies-response.json",
+	}
+
+	bundle, _ := m.Map(context.Background(), createTestNotification())
+	consent, _ := fhir.UnmarshalConsent(bundle.Entry[0].Resource)
+
+	assert.Equal(t, "Patient?identifier=https://fhir.diz.uni-marburg.de/sid/patient-id|42", *consent.Patient.Reference)
+	assert.Len(t, bundle.Entry, 2)
+}
+
+func TestNameUuid(t *testing.T) {
+	u := nameUuid("https://fhir.diz.uni-marburg.de/sid/patient-id", "42")
+
+	assert.Regexp(t, "^[0-9a-f]{8}-[0-9a-f]{4}-5[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$", u)
+	assert.Equal(t, u, nameUuid("https://fhir.diz.uni-marburg.de/sid/patient-id", "42"))
+	assert.NotEqual(t, u, nameUuid("https://fhir.diz.uni-marburg.de/sid/patient-id", "43"))
+}
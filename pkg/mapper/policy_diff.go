@@ -0,0 +1,67 @@
+package mapper
+
+import (
+	"consent-to-fhir/pkg/model"
+	"sort"
+)
+
+// PolicyChange describes a changed policy state of a notification
+type PolicyChange struct {
+	Name     string `json:"name"`
+	Version  string `json:"version"`
+	Previous bool   `json:"previous"`
+	Current  bool   `json:"current"`
+}
+
+type policyKey struct {
+	name    string
+	version string
+}
+
+// DiffPolicyStates compares the effective previous and current policy states of the notification's domain.
+// Missing policy states are considered not permitted
+func DiffPolicyStates(n model.Notification) []PolicyChange {
+	domain := ""
+	if n.ConsentKey != nil && n.ConsentKey.ConsentTemplateKey != nil && n.ConsentKey.ConsentTemplateKey.DomainName != nil {
+		domain = *n.ConsentKey.ConsentTemplateKey.DomainName
+	}
+
+	previous := effectiveStates(domain, n.PreviousPolicyStates)
+	current := effectiveStates(domain, n.CurrentPolicyStates)
+
+	var changes []PolicyChange
+	for k, v := range current {
+		if previous[k] != v {
+			changes = append(changes, PolicyChange{Name: k.name, Version: k.version, Previous: previous[k], Current: v})
+		}
+	}
+	for k, v := range previous {
+		if _, ok := current[k]; !ok && v {
+			changes = append(changes, PolicyChange{Name: k.name, Version: k.version, Previous: v, Current: false})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Name == changes[j].Name {
+			return changes[i].Version < changes[j].Version
+		}
+		return changes[i].Name < changes[j].Name
+	})
+	return changes
+}
+
+func effectiveStates(domain string, states []model.PolicyState) map[policyKey]bool {
+	values := make(map[policyKey]bool)
+	for _, s := range states {
+		if s.Key == nil || s.Key.Name == nil || (s.Key.DomainName != nil && *s.Key.DomainName != domain) {
+			continue
+		}
+
+		k := policyKey{name: *s.Key.Name}
+		if s.Key.Version != nil {
+			k.version = *s.Key.Version
+		}
+		values[k] = s.Value
+	}
+	return values
+}
@@ -0,0 +1,62 @@
+package mapper
+
+import (
+	"consent-to-fhir/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDiffPolicyStates(t *testing.T) {
+	otherDomain := policyState("IDAT_erheben", "1.0", true)
+	otherDomain.Key.DomainName = Of("Other")
+
+	cases := []struct {
+		name     string
+		previous []model.PolicyState
+		current  []model.PolicyState
+		expected []PolicyChange
+	}{
+		{
+			name:     "unchanged",
+			previous: []model.PolicyState{policyState("IDAT_erheben", "1.0", true), policyState("MDAT_erheben", "1.1", false)},
+			current:  []model.PolicyState{policyState("MDAT_erheben", "1.1", false), policyState("IDAT_erheben", "1.0", true)},
+			expected: nil,
+		},
+		{
+			name:     "changed",
+			previous: []model.PolicyState{policyState("IDAT_erheben", "1.0", false), policyState("MDAT_erheben", "1.1", true)},
+			current:  []model.PolicyState{policyState("IDAT_erheben", "1.0", true), policyState("MDAT_erheben", "1.1", false)},
+			expected: []PolicyChange{
+				{Name: "IDAT_erheben", Version: "1.0", Previous: false, Current: true},
+				{Name: "MDAT_erheben", Version: "1.1", Previous: true, Current: false},
+			},
+		},
+		{
+			name:     "missingIsNotPermitted",
+			previous: nil,
+			current:  []model.PolicyState{policyState("IDAT_erheben", "1.0", false), policyState("MDAT_erheben", "1.1", true)},
+			expected: []PolicyChange{{Name: "MDAT_erheben", Version: "1.1", Previous: false, Current: true}},
+		},
+		{
+			name:     "removed",
+			previous: []model.PolicyState{policyState("IDAT_erheben", "1.0", true)},
+			current:  nil,
+			expected: []PolicyChange{{Name: "IDAT_erheben", Version: "1.0", Previous: true, Current: false}},
+		},
+		{
+			name:     "otherDomainIgnored",
+			previous: nil,
+			current:  []model.PolicyState{otherDomain},
+			expected: nil,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			n := createTestNotification(c.current...)
+			n.PreviousPolicyStates = c.previous
+
+			assert.Equal(t, c.expected, DiffPolicyStates(n))
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package mapper
+
+import (
+	"consent-to-fhir/pkg/model"
+	"fmt"
+	"github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"strings"
+	"time"
+)
+
+// Version of consent-to-fhir, set at build time
+var Version = "dev"
+
+// Source describes the input message of a notification or, for consents re-evaluated by the expiry scheduler,
+// the re-evaluation date
+type Source struct {
+	Topic       string
+	Partition   int32
+	Offset      int64
+	EvaluatedAt *time.Time
+}
+
+func (s Source) describe() string {
+	if s.EvaluatedAt != nil {
+		return "Expiry re-evaluation at " + s.EvaluatedAt.Format(time.RFC3339)
+	}
+	return fmt.Sprintf("Kafka message %s[%d]@%d", s.Topic, s.Partition, s.Offset)
+}
+
+// AddProvenance adds a Provenance entry to the bundle, which targets the bundle's Consent resource and
+// records the notification and its input message or re-evaluation as source entities
+func AddProvenance(bundle *fhir.Bundle, n model.Notification, src Source) error {
+	target := consentTarget(bundle)
+	if target == nil {
+		// deleted or no consent
+		return nil
+	}
+
+	p := fhir.Provenance{
+		Target:   []fhir.Reference{{Reference: target}},
+		Recorded: time.Now().Format(time.RFC3339),
+		Activity: &fhir.CodeableConcept{Coding: []fhir.Coding{{
+			System: Of("http://terminology.hl7.org/CodeSystem/v3-DataOperation"),
+			Code:   Of("UPDATE"),
+		}}},
+		Agent: []fhir.ProvenanceAgent{{
+			Type: &fhir.CodeableConcept{Coding: []fhir.Coding{{
+				System: Of("http://terminology.hl7.org/CodeSystem/provenance-participant-type"),
+				Code:   Of("assembler"),
+			}}},
+			Who: fhir.Reference{Display: Of("consent-to-fhir " + Version)},
+		}},
+		Entity: []fhir.ProvenanceEntity{
+			{
+				Role: fhir.ProvenanceEntityRoleSource,
+				What: fhir.Reference{Display: Of(describeNotification(n))},
+			},
+			{
+				Role: fhir.ProvenanceEntityRoleSource,
+				What: fhir.Reference{Display: Of(src.describe())},
+			},
+		},
+	}
+	switch {
+	case src.EvaluatedAt != nil:
+		p.OccurredDateTime = Of(src.EvaluatedAt.Format(time.RFC3339))
+	case n.ConsentKey != nil && n.ConsentKey.ConsentDate != nil:
+		if date, err := parseConsentDate(*n.ConsentKey.ConsentDate); err == nil {
+			p.OccurredDateTime = &date
+		}
+	}
+
+	data, err := p.MarshalJSON()
+	if err != nil {
+		return err
+	}
+
+	bundle.Entry = append(bundle.Entry, fhir.BundleEntry{
+		Resource: data,
+		Request: &fhir.BundleEntryRequest{
+			Method: fhir.HTTPVerbPOST,
+			Url:    "Provenance",
+		},
+	})
+	return nil
+}
+
+// consentTarget returns the conditional reference of the Consent resource, which is created or updated
+func consentTarget(bundle *fhir.Bundle) *string {
+	if bundle == nil {
+		return nil
+	}
+
+	for _, e := range bundle.Entry {
+		if e.Request != nil && e.Request.Method == fhir.HTTPVerbPUT && strings.HasPrefix(e.Request.Url, "Consent") {
+			return Of(e.Request.Url)
+		}
+	}
+	return nil
+}
+
+func describeNotification(n model.Notification) string {
+	notificationType := n.Type
+	if notificationType == "" {
+		notificationType = "unknown"
+	}
+	desc := "gICS notification " + notificationType
+
+	if k := n.ConsentKey; k != nil {
+		if t := k.ConsentTemplateKey; t != nil {
+			desc += fmt.Sprintf(", consent template %s|%s|%s", deref(t.DomainName), deref(t.Name), deref(t.Version))
+		}
+		if k.ConsentDate != nil {
+			desc += ", consent date " + *k.ConsentDate
+		}
+	}
+
+	return desc
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,61 @@
+package mapper
+
+import (
+	"consent-to-fhir/pkg/model"
+	"context"
+	"github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestAddProvenance(t *testing.T) {
+	m := createTestMapper()
+	m.Client = &TestGicsClient{
+		respFilePath: "testdata/current-policies-response.json",
+	}
+	n := createTestNotification()
+	n.Type = model.AddConsent
+
+	bundle, _ := m.Map(context.Background(), n)
+	err := AddProvenance(bundle, n, Source{Topic: "consent-json", Partition: 1, Offset: 42})
+
+	actual, _ := fhir.UnmarshalProvenance(bundle.Entry[len(bundle.Entry)-1].Resource)
+
+	assert.NoError(t, err)
+	assert.Equal(t, bundle.Entry[0].Request.Url, *actual.Target[0].Reference)
+	assert.Equal(t, "2023-05-02", (*actual.OccurredDateTime)[:10])
+	assert.Equal(t, "consent-to-fhir dev", *actual.Agent[0].Who.Display)
+	assert.Equal(t, "gICS notification GICS.AddConsent, consent template MII|Patienteneinwilligung MII|1.6.d, "+
+		"consent date 2023-05-02 01:57:27", *actual.Entity[0].What.Display)
+	assert.Equal(t, "Kafka message consent-json[1]@42", *actual.Entity[1].What.Display)
+}
+
+func TestAddProvenance_Reevaluated(t *testing.T) {
+	m := createTestMapper()
+	m.Client = &TestGicsClient{
+		respFilePath: "testdata/current-policies-response.json",
+	}
+	n := createTestNotification()
+	n.Type = model.AddConsent
+	due := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	bundle, _ := m.Map(context.Background(), n)
+	err := AddProvenance(bundle, n, Source{EvaluatedAt: &due})
+
+	actual, _ := fhir.UnmarshalProvenance(bundle.Entry[len(bundle.Entry)-1].Resource)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "2025-03-01T00:00:00Z", *actual.OccurredDateTime)
+	assert.Equal(t, "Expiry re-evaluation at 2025-03-01T00:00:00Z", *actual.Entity[1].What.Display)
+}
+
+func TestAddProvenance_DeleteBundle(t *testing.T) {
+	m := createTestMapper()
+	bundle, _ := m.createDeleteBundle("MII", "42")
+
+	err := AddProvenance(bundle, createTestNotification(), Source{})
+
+	assert.NoError(t, err)
+	assert.Len(t, bundle.Entry, 1)
+}
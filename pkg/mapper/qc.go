@@ -0,0 +1,54 @@
+package mapper
+
+import (
+	"consent-to-fhir/pkg/model"
+	"github.com/samply/golang-fhir-models/fhir-models/fhir"
+)
+
+const (
+	QcPolicyNone     = "none"
+	QcPolicyHold     = "hold"
+	QcPolicyProposed = "proposed"
+
+	qcExtensionUrl = "https://fhir.diz.uni-marburg.de/fhir/StructureDefinition/consent-quality-control"
+)
+
+// qcPending checks if the notification's consent is subject to the quality control policy, i.e. it has a
+// quality control result, which has not passed. Revocations and refusals are never held back
+func qcPending(n model.Notification) bool {
+	if n.Type == model.Revoke || n.Type == model.RefuseConsent || n.Context == nil || n.Context.Qc == nil {
+		return false
+	}
+	return !n.Context.Qc.QcPassed
+}
+
+// qcPolicy returns the configured policy for consents which have not passed quality control (default: none)
+func (m *GicsMapper) qcPolicy(domain string) string {
+	if p := m.Config.Domains[domain].QcPolicy; p != "" {
+		return p
+	}
+	return QcPolicyNone
+}
+
+// setQcExtension adds the quality control result as an extension
+func setQcExtension(extensions []fhir.Extension, qc *model.Qc) []fhir.Extension {
+	if qc == nil {
+		return extensions
+	}
+
+	ext := fhir.Extension{
+		Url:       qcExtensionUrl,
+		Extension: []fhir.Extension{{Url: "passed", ValueBoolean: Of(qc.QcPassed)}},
+	}
+	for _, e := range []struct{ url, value string }{
+		{"type", qc.Type},
+		{"inspector", qc.Inspector},
+		{"comment", qc.Comment},
+	} {
+		if e.value != "" {
+			ext.Extension = append(ext.Extension, fhir.Extension{Url: e.url, ValueString: Of(e.value)})
+		}
+	}
+
+	return append(extensions, ext)
+}
@@ -0,0 +1,113 @@
+package mapper
+
+import (
+	"consent-to-fhir/pkg/config"
+	"consent-to-fhir/pkg/model"
+	"context"
+	"github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSetQcExtension(t *testing.T) {
+	qc := &model.Qc{QcPassed: true, Type: "checked_no_faults", Inspector: "inspector"}
+
+	actual := setQcExtension(nil, qc)
+
+	assert.Equal(t, []fhir.Extension{{
+		Url: qcExtensionUrl,
+		Extension: []fhir.Extension{
+			{Url: "passed", ValueBoolean: Of(true)},
+			{Url: "type", ValueString: Of("checked_no_faults")},
+			{Url: "inspector", ValueString: Of("inspector")},
+		},
+	}}, actual)
+	assert.Nil(t, setQcExtension(nil, nil))
+}
+
+func TestMap_QcPolicy(t *testing.T) {
+	failed := &model.Context{Qc: &model.Qc{QcPassed: false, Type: "not_checked"}}
+
+	cases := []struct {
+		name             string
+		policy           string
+		notificationType string
+		context          *model.Context
+		expectedBundle   bool
+		expectedStatus   fhir.ConsentState
+	}{
+		{
+			name:           "passed",
+			policy:         QcPolicyHold,
+			context:        &model.Context{Qc: &model.Qc{QcPassed: true, Type: "checked_no_faults"}},
+			expectedBundle: true,
+			expectedStatus: fhir.ConsentStateActive,
+		},
+		{
+			name:           "none",
+			policy:         "",
+			context:        failed,
+			expectedBundle: true,
+			expectedStatus: fhir.ConsentStateActive,
+		},
+		{
+			name:           "hold",
+			policy:         QcPolicyHold,
+			context:        failed,
+			expectedBundle: false,
+		},
+		{
+			name:           "holdMissingContext",
+			policy:         QcPolicyHold,
+			context:        nil,
+			expectedBundle: true,
+			expectedStatus: fhir.ConsentStateActive,
+		},
+		{
+			name:             "holdRevoke",
+			policy:           QcPolicyHold,
+			notificationType: model.Revoke,
+			context:          failed,
+			expectedBundle:   true,
+			expectedStatus:   fhir.ConsentStateActive,
+		},
+		{
+			name:           "proposed",
+			policy:         QcPolicyProposed,
+			context:        failed,
+			expectedBundle: true,
+			expectedStatus: fhir.ConsentStateProposed,
+		},
+		{
+			name:             "proposedRefusal",
+			policy:           QcPolicyProposed,
+			notificationType: model.RefuseConsent,
+			context:          failed,
+			expectedBundle:   true,
+			expectedStatus:   fhir.ConsentStateActive,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			m := createTestMapper()
+			m.Config.Domains = map[string]config.Domain{"MII": {QcPolicy: c.policy}}
+			m.Client = &TestGicsClient{
+				respFilePath: "testdata/current-policies-response.json",
+			}
+			n := createTestNotification()
+			n.Type = c.notificationType
+			n.Context = c.context
+
+			bundle, err := m.Map(context.Background(), n)
+
+			assert.NoError(t, err)
+			if !c.expectedBundle {
+				assert.Nil(t, bundle)
+				return
+			}
+			actual, _ := fhir.UnmarshalConsent(bundle.Entry[0].Resource)
+			assert.Equal(t, c.expectedStatus, actual.Status)
+		})
+	}
+}
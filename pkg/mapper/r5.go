@@ -0,0 +1,263 @@
+package mapper
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/samply/golang-fhir-models/fhir-models/fhir"
+	log "github.com/sirupsen/logrus"
+	"reflect"
+)
+
+const (
+	OutputR4 = "R4"
+	OutputR5 = "R5"
+)
+
+// ConsentR5 is the FHIR R5 Consent resource, as far as it is mapped from R4
+type ConsentR5 struct {
+	Id               *string                 `json:"id,omitempty"`
+	Meta             *fhir.Meta              `json:"meta,omitempty"`
+	Extension        []fhir.Extension        `json:"extension,omitempty"`
+	Identifier       []fhir.Identifier       `json:"identifier,omitempty"`
+	Status           string                  `json:"status"`
+	Category         []fhir.CodeableConcept  `json:"category,omitempty"`
+	Subject          *fhir.Reference         `json:"subject,omitempty"`
+	Date             *string                 `json:"date,omitempty"`
+	Period           *fhir.Period            `json:"period,omitempty"`
+	Grantor          []fhir.Reference        `json:"grantor,omitempty"`
+	Manager          []fhir.Reference        `json:"manager,omitempty"`
+	Controller       []fhir.Reference        `json:"controller,omitempty"`
+	SourceAttachment []fhir.Attachment       `json:"sourceAttachment,omitempty"`
+	SourceReference  []fhir.Reference        `json:"sourceReference,omitempty"`
+	RegulatoryBasis  []fhir.CodeableConcept  `json:"regulatoryBasis,omitempty"`
+	PolicyBasis      *ConsentR5PolicyBasis   `json:"policyBasis,omitempty"`
+	Verification     []ConsentR5Verification `json:"verification,omitempty"`
+	Decision         *string                 `json:"decision,omitempty"`
+	Provision        []ConsentR5Provision    `json:"provision,omitempty"`
+}
+
+type ConsentR5PolicyBasis struct {
+	Reference *fhir.Reference `json:"reference,omitempty"`
+	Url       *string         `json:"url,omitempty"`
+}
+
+type ConsentR5Verification struct {
+	Verified         bool            `json:"verified"`
+	VerifiedWith     *fhir.Reference `json:"verifiedWith,omitempty"`
+	VerificationDate []string        `json:"verificationDate,omitempty"`
+}
+
+type ConsentR5Provision struct {
+	Extension     []fhir.Extension             `json:"extension,omitempty"`
+	Period        *fhir.Period                 `json:"period,omitempty"`
+	Actor         []fhir.ConsentProvisionActor `json:"actor,omitempty"`
+	Action        []fhir.CodeableConcept       `json:"action,omitempty"`
+	SecurityLabel []fhir.Coding                `json:"securityLabel,omitempty"`
+	Purpose       []fhir.Coding                `json:"purpose,omitempty"`
+	ResourceType  []fhir.Coding                `json:"resourceType,omitempty"`
+	Code          []fhir.CodeableConcept       `json:"code,omitempty"`
+	DataPeriod    *fhir.Period                 `json:"dataPeriod,omitempty"`
+	Data          []fhir.ConsentProvisionData  `json:"data,omitempty"`
+	Provision     []ConsentR5Provision         `json:"provision,omitempty"`
+}
+
+type OtherConsentR5 ConsentR5
+
+func (r ConsentR5) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		OtherConsentR5
+		ResourceType string `json:"resourceType"`
+	}{
+		OtherConsentR5: OtherConsentR5(r),
+		ResourceType:   "Consent",
+	})
+}
+
+// ResearchStudyR5 is the FHIR R5 ResearchStudy resource, as far as it is mapped from R4
+type ResearchStudyR5 struct {
+	Id          *string           `json:"id,omitempty"`
+	Meta        *fhir.Meta        `json:"meta,omitempty"`
+	Extension   []fhir.Extension  `json:"extension,omitempty"`
+	Identifier  []fhir.Identifier `json:"identifier,omitempty"`
+	Title       *string           `json:"title,omitempty"`
+	Status      string            `json:"status"`
+	Description *string           `json:"description,omitempty"`
+}
+
+type OtherResearchStudyR5 ResearchStudyR5
+
+func (r ResearchStudyR5) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		OtherResearchStudyR5
+		ResourceType string `json:"resourceType"`
+	}{
+		OtherResearchStudyR5: OtherResearchStudyR5(r),
+		ResourceType:         "ResearchStudy",
+	})
+}
+
+// ConvertBundleR5 converts the bundle's R4 Consent and ResearchStudy resources to R5. Other resources
+// are kept as is
+func ConvertBundleR5(bundle *fhir.Bundle) error {
+	for i, e := range bundle.Entry {
+		if e.Resource == nil {
+			continue
+		}
+
+		var res struct {
+			ResourceType string `json:"resourceType"`
+		}
+		if err := json.Unmarshal(e.Resource, &res); err != nil {
+			return err
+		}
+
+		var r5 json.Marshaler
+		switch res.ResourceType {
+		case "Consent":
+			c, err := fhir.UnmarshalConsent(e.Resource)
+			if err != nil {
+				return err
+			}
+			r5 = ConsentToR5(c)
+		case "ResearchStudy":
+			s, err := fhir.UnmarshalResearchStudy(e.Resource)
+			if err != nil {
+				return err
+			}
+			r5 = ResearchStudyToR5(s)
+		default:
+			continue
+		}
+
+		data, err := r5.MarshalJSON()
+		if err != nil {
+			return fmt.Errorf("failed to convert %s resource to R5: %w", res.ResourceType, err)
+		}
+		bundle.Entry[i].Resource = data
+	}
+
+	return nil
+}
+
+// ConsentToR5 converts an R4 Consent resource to R5. The top-level provision's type and period
+// become the consent's decision and period. The R4 organization is both manager and controller of the consent
+func ConsentToR5(c fhir.Consent) ConsentR5 {
+	r := ConsentR5{
+		Id:         c.Id,
+		Meta:       metaToR5(c.Meta),
+		Extension:  c.Extension,
+		Identifier: c.Identifier,
+		Status:     consentStatusR5(c.Status),
+		Category:   c.Category,
+		Subject:    c.Patient,
+		Grantor:    c.Performer,
+		Manager:    c.Organization,
+		Controller: c.Organization,
+	}
+	if c.DateTime != nil && len(*c.DateTime) >= 10 {
+		// dateTime to date
+		r.Date = Of((*c.DateTime)[:10])
+	}
+	if c.SourceAttachment != nil {
+		r.SourceAttachment = []fhir.Attachment{*c.SourceAttachment}
+	}
+	if c.SourceReference != nil {
+		r.SourceReference = []fhir.Reference{*c.SourceReference}
+	}
+	if c.PolicyRule != nil {
+		r.RegulatoryBasis = []fhir.CodeableConcept{*c.PolicyRule}
+	}
+	if len(c.Policy) > 0 {
+		if len(c.Policy) > 1 {
+			log.WithField("id", deref(c.Id)).Warn("R5 Consent supports a single policy basis only. Using first policy")
+		}
+		r.PolicyBasis = &ConsentR5PolicyBasis{Url: c.Policy[0].Uri}
+	}
+	for _, v := range c.Verification {
+		verification := ConsentR5Verification{Verified: v.Verified, VerifiedWith: v.VerifiedWith}
+		if v.VerificationDate != nil {
+			verification.VerificationDate = []string{*v.VerificationDate}
+		}
+		r.Verification = append(r.Verification, verification)
+	}
+
+	if p := c.Provision; p != nil {
+		if p.Type != nil {
+			r.Decision = Of(p.Type.Code())
+		}
+		r.Period = p.Period
+		r.Provision = provisionsToR5(p.Provision)
+	}
+
+	return r
+}
+
+// metaToR5 removes the R4 profiles, which R5 resources do not conform to. Returns nil, if nothing else is left
+func metaToR5(meta *fhir.Meta) *fhir.Meta {
+	if meta == nil {
+		return nil
+	}
+
+	r := *meta
+	r.Profile = nil
+	if reflect.ValueOf(r).IsZero() {
+		return nil
+	}
+	return &r
+}
+
+func provisionsToR5(provisions []fhir.ConsentProvision) []ConsentR5Provision {
+	var result []ConsentR5Provision
+	for _, p := range provisions {
+		result = append(result, ConsentR5Provision{
+			Extension:     p.Extension,
+			Period:        p.Period,
+			Actor:         p.Actor,
+			Action:        p.Action,
+			SecurityLabel: p.SecurityLabel,
+			Purpose:       p.Purpose,
+			ResourceType:  p.Class,
+			Code:          p.Code,
+			DataPeriod:    p.DataPeriod,
+			Data:          p.Data,
+			Provision:     provisionsToR5(p.Provision),
+		})
+	}
+	return result
+}
+
+func consentStatusR5(status fhir.ConsentState) string {
+	switch status {
+	case fhir.ConsentStateProposed:
+		return "draft"
+	case fhir.ConsentStateRejected:
+		return "not-done"
+	default:
+		return status.Code()
+	}
+}
+
+// ResearchStudyToR5 converts an R4 ResearchStudy resource to R5
+func ResearchStudyToR5(s fhir.ResearchStudy) ResearchStudyR5 {
+	return ResearchStudyR5{
+		Id:          s.Id,
+		Meta:        metaToR5(s.Meta),
+		Extension:   s.Extension,
+		Identifier:  s.Identifier,
+		Title:       s.Title,
+		Status:      researchStudyStatusR5(s.Status),
+		Description: s.Description,
+	}
+}
+
+// researchStudyStatusR5 maps the R4 study status to the R5 publication status
+func researchStudyStatusR5(status fhir.ResearchStudyStatus) string {
+	switch status {
+	case fhir.ResearchStudyStatusActive:
+		return "active"
+	case fhir.ResearchStudyStatusInReview, fhir.ResearchStudyStatusApproved:
+		return "draft"
+	default:
+		return "retired"
+	}
+}
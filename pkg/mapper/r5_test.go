@@ -0,0 +1,172 @@
+package mapper
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func createTestBundleR5(t *testing.T, respFilePath string) *fhir.Bundle {
+	m := createTestMapper()
+	m.Client = &TestGicsClient{
+		respFilePath: respFilePath,
+	}
+
+	bundle, err := m.Map(context.Background(), createTestNotification())
+	assert.NoError(t, err)
+
+	err = ConvertBundleR5(bundle)
+	assert.NoError(t, err)
+
+	return bundle
+}
+
+func TestConvertBundleR5(t *testing.T) {
+	bundle := createTestBundleR5(t, "testdata/current-policies-response.json")
+
+	var consent map[string]any
+	_ = json.Unmarshal(bundle.Entry[0].Resource, &consent)
+
+	assert.Equal(t, "Consent", consent["resourceType"])
+	assert.Equal(t, "active", consent["status"])
+	assert.Equal(t, "deny", consent["decision"])
+	assert.Equal(t, "2023-12-11", consent["date"])
+	assert.Equal(t, map[string]any{"reference": "Patient?identifier=https://fhir.diz.uni-marburg.de/sid/patient-id|42"},
+		consent["subject"])
+	assert.NotNil(t, consent["period"])
+	assert.NotEmpty(t, consent["provision"])
+
+	// R4 elements and profiles
+	for _, e := range []string{"patient", "dateTime", "scope", "organization", "policy", "policyRule"} {
+		assert.NotContains(t, consent, e)
+	}
+	assert.NotContains(t, consent["meta"], "profile")
+	for _, p := range consent["provision"].([]any) {
+		assert.NotContains(t, p, "type")
+	}
+
+	var study map[string]any
+	_ = json.Unmarshal(bundle.Entry[1].Resource, &study)
+
+	assert.Equal(t, "ResearchStudy", study["resourceType"])
+	assert.Equal(t, "active", study["status"])
+}
+
+func TestConsentToR5_Meta(t *testing.T) {
+	cases := []struct {
+		name     string
+		meta     *fhir.Meta
+		expected *fhir.Meta
+	}{
+		{"none", nil, nil},
+		{"profileOnly", &fhir.Meta{Profile: []string{MiiProfile}}, nil},
+		{"source", &fhir.Meta{Profile: []string{MiiProfile}, Source: Of("gics")}, &fhir.Meta{Source: Of("gics")}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			consent := ConsentToR5(fhir.Consent{Meta: c.meta})
+			study := ResearchStudyToR5(fhir.ResearchStudy{Meta: c.meta})
+
+			assert.Equal(t, c.expected, consent.Meta)
+			assert.Equal(t, c.expected, study.Meta)
+			if c.meta != nil {
+				// not modified
+				assert.Equal(t, []string{MiiProfile}, c.meta.Profile)
+			}
+		})
+	}
+}
+
+func TestConsentToR5_Organization(t *testing.T) {
+	organization := []fhir.Reference{{Reference: Of("Organization/1")}}
+
+	r := ConsentToR5(fhir.Consent{Organization: organization})
+
+	assert.Equal(t, organization, r.Manager)
+	assert.Equal(t, organization, r.Controller)
+}
+
+func TestConvertBundleR5_Provisions(t *testing.T) {
+	m := createTestMapper()
+	m.Client = &TestGicsClient{
+		respFilePath: "testdata/current-policies-response.json",
+	}
+	bundle, _ := m.Map(context.Background(), createTestNotification())
+	r4, _ := fhir.UnmarshalConsent(bundle.Entry[0].Resource)
+
+	_ = ConvertBundleR5(bundle)
+	var r5 ConsentR5
+	_ = json.Unmarshal(bundle.Entry[0].Resource, &r5)
+
+	assert.NotEmpty(t, r4.Meta.Profile)
+	assert.Empty(t, r5.Meta.Profile)
+	assert.Equal(t, r4.Provision.Period, r5.Period)
+	assert.Len(t, r5.Provision, len(r4.Provision.Provision))
+	for i, p := range r4.Provision.Provision {
+		assert.Equal(t, p.Code, r5.Provision[i].Code)
+		assert.Equal(t, p.Period, r5.Provision[i].Period)
+	}
+}
+
+func TestConvertBundleR5_Delete(t *testing.T) {
+	bundle := createTestBundleR5(t, "testdata/empty-policies-response.json")
+
+	assert.Len(t, bundle.Entry, 1)
+	assert.Nil(t, bundle.Entry[0].Resource)
+}
+
+func TestConsentToR5_Status(t *testing.T) {
+	cases := []struct {
+		status   fhir.ConsentState
+		expected string
+	}{
+		{fhir.ConsentStateDraft, "draft"},
+		{fhir.ConsentStateProposed, "draft"},
+		{fhir.ConsentStateActive, "active"},
+		{fhir.ConsentStateRejected, "not-done"},
+		{fhir.ConsentStateInactive, "inactive"},
+		{fhir.ConsentStateEnteredInError, "entered-in-error"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.status.Code(), func(t *testing.T) {
+			assert.Equal(t, c.expected, ConsentToR5(fhir.Consent{Status: c.status}).Status)
+		})
+	}
+}
+
+func TestConsentToR5_Policy(t *testing.T) {
+	c := fhir.Consent{
+		Policy:       []fhir.ConsentPolicy{{Uri: Of("urn:oid:2.16.840.1.113883.3.1937.777.24.2.1790")}},
+		Verification: []fhir.ConsentVerification{{Verified: true, VerificationDate: Of("2023-12-11")}},
+	}
+
+	r := ConsentToR5(c)
+
+	assert.Equal(t, &ConsentR5PolicyBasis{Url: Of("urn:oid:2.16.840.1.113883.3.1937.777.24.2.1790")}, r.PolicyBasis)
+	assert.Equal(t, []ConsentR5Verification{{Verified: true, VerificationDate: []string{"2023-12-11"}}}, r.Verification)
+}
+
+func TestResearchStudyToR5(t *testing.T) {
+	cases := []struct {
+		status   fhir.ResearchStudyStatus
+		expected string
+	}{
+		{fhir.ResearchStudyStatusActive, "active"},
+		{fhir.ResearchStudyStatusApproved, "draft"},
+		{fhir.ResearchStudyStatusCompleted, "retired"},
+		{fhir.ResearchStudyStatusWithdrawn, "retired"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.status.Code(), func(t *testing.T) {
+			s := ResearchStudyToR5(fhir.ResearchStudy{Title: Of("MII"), Status: c.status})
+
+			assert.Equal(t, c.expected, s.Status)
+			assert.Equal(t, "MII", *s.Title)
+		})
+	}
+}
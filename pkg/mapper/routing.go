@@ -0,0 +1,49 @@
+package mapper
+
+import (
+	"consent-to-fhir/pkg/config"
+	"consent-to-fhir/pkg/model"
+)
+
+// DomainAllowed checks if notifications of the domain should be processed. If domains are restricted,
+// only configured domains are allowed
+func DomainAllowed(c config.Mapper, domain string) bool {
+	if !c.RestrictDomains {
+		return true
+	}
+	_, ok := c.Domains[domain]
+	return ok
+}
+
+// DomainOf returns the notification's consent domain or an empty string, if unknown
+func DomainOf(n model.Notification) string {
+	if n.ConsentKey == nil || n.ConsentKey.ConsentTemplateKey == nil || n.ConsentKey.ConsentTemplateKey.DomainName == nil {
+		return ""
+	}
+	return *n.ConsentKey.ConsentTemplateKey.DomainName
+}
+
+// profileOf returns the domain's consent profile, which overrides app.mapper.profiles
+func profileOf(c config.Mapper, domain string) (string, bool) {
+	if p := c.Domains[domain].Profile; p != "" {
+		return p, true
+	}
+	p, ok := c.Profiles[domain]
+	return p, ok
+}
+
+// consentSystemOf returns the domain's consent identifier system, which overrides app.mapper.consent-system
+func consentSystemOf(c config.Mapper, domain string) *string {
+	if s := c.Domains[domain].ConsentSystem; s != "" {
+		return &s
+	}
+	return c.ConsentSystem
+}
+
+// patientSystemOf returns the domain's patient identifier system, which overrides app.mapper.patient-system
+func patientSystemOf(c config.Mapper, domain string) string {
+	if s := c.Domains[domain].PatientSystem; s != "" {
+		return s
+	}
+	return *c.PatientSystem
+}
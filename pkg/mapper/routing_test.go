@@ -0,0 +1,76 @@
+package mapper
+
+import (
+	"consent-to-fhir/pkg/config"
+	"consent-to-fhir/pkg/model"
+	"context"
+	"github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDomainAllowed(t *testing.T) {
+	cases := []struct {
+		name     string
+		config   config.Mapper
+		domain   string
+		expected bool
+	}{
+		{
+			name:     "unrestricted",
+			config:   config.Mapper{},
+			domain:   "MII",
+			expected: true,
+		},
+		{
+			name:     "configured",
+			config:   config.Mapper{RestrictDomains: true, Domains: map[string]config.Domain{"MII": {}}},
+			domain:   "MII",
+			expected: true,
+		},
+		{
+			name:     "not configured",
+			config:   config.Mapper{RestrictDomains: true, Domains: map[string]config.Domain{"MII": {}}},
+			domain:   "Biobank",
+			expected: false,
+		},
+		{
+			name:     "unknown domain",
+			config:   config.Mapper{RestrictDomains: true, Domains: map[string]config.Domain{"MII": {}}},
+			domain:   "",
+			expected: false,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.expected, DomainAllowed(c.config, c.domain))
+		})
+	}
+}
+
+func TestDomainOf(t *testing.T) {
+	assert.Equal(t, "MII", DomainOf(createTestNotification()))
+	assert.Equal(t, "", DomainOf(model.Notification{}))
+}
+
+func TestProcess_DomainRouting(t *testing.T) {
+	m := createTestMapper()
+	m.Config.Domains = map[string]config.Domain{"MII": {
+		Profile:       consentManagementProfile,
+		ConsentSystem: "https://fhir.diz.uni-marburg.de/sid/mii-consent-id",
+		PatientSystem: "https://fhir.diz.uni-marburg.de/sid/mii-patient-id",
+	}}
+	m.Client = &TestGicsClient{
+		respFilePath: "testdata/current-policies-response.json",
+	}
+
+	bundle, _ := m.Map(context.Background(), createTestNotification())
+	consent, _ := fhir.UnmarshalConsent(bundle.Entry[0].Resource)
+
+	assert.Equal(t, []string{consentManagementProfile}, consent.Meta.Profile)
+	assert.Equal(t, "https://fhir.diz.uni-marburg.de/sid/mii-consent-id", *consent.Identifier[0].System)
+	assert.Equal(t, "Consent?identifier=https://fhir.diz.uni-marburg.de/sid/mii-consent-id|"+*consent.Id,
+		bundle.Entry[0].Request.Url)
+	assert.Equal(t, "Patient?identifier=https://fhir.diz.uni-marburg.de/sid/mii-patient-id|42", *consent.Patient.Reference)
+}
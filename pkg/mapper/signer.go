@@ -0,0 +1,64 @@
+package mapper
+
+import (
+	"consent-to-fhir/pkg/config"
+	"consent-to-fhir/pkg/model"
+	"errors"
+	"fmt"
+	"github.com/samply/golang-fhir-models/fhir-models/fhir"
+)
+
+const gicsIdentifierSystem = "https://ths-greifswald.de/fhir/gics/identifiers/"
+
+// selectSigner picks the primary signer id by the configured id type priority and returns it
+// along with the remaining signer ids
+func selectSigner(ids []model.SignerId, c config.Signer) (model.SignerId, []model.SignerId, error) {
+	if len(ids) == 0 {
+		return model.SignerId{}, nil, errors.New("notification contains no signer ids")
+	}
+
+	// default: first signer id
+	primary := 0
+	if len(c.IdTypes) > 0 {
+		primary = -1
+	found:
+		for _, t := range c.IdTypes {
+			for i, id := range ids {
+				if id.IdType == t {
+					primary = i
+					break found
+				}
+			}
+		}
+		if primary < 0 {
+			return model.SignerId{}, nil, fmt.Errorf("no signer id found for id types %v", c.IdTypes)
+		}
+	}
+
+	var others []model.SignerId
+	for i, id := range ids {
+		if i != primary {
+			others = append(others, id)
+		}
+	}
+
+	return ids[primary], others, nil
+}
+
+// signerIdentifiers creates identifiers for signer ids with their configured system per id type
+func signerIdentifiers(ids []model.SignerId, c config.Signer) []fhir.Identifier {
+	var identifiers []fhir.Identifier
+	for _, id := range ids {
+		system, ok := c.Systems[id.IdType]
+		if !ok {
+			system = gicsIdentifierSystem + id.IdType
+		}
+
+		identifiers = append(identifiers, fhir.Identifier{
+			System: Of(system),
+			Value:  Of(id.Id),
+		})
+	}
+
+	return identifiers
+}
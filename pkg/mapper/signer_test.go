@@ -0,0 +1,87 @@
+package mapper
+
+import (
+	"consent-to-fhir/pkg/config"
+	"consent-to-fhir/pkg/model"
+	"github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSelectSigner(t *testing.T) {
+	pid := model.SignerId{IdType: "Patienten-ID", Id: "42"}
+	psn := model.SignerId{IdType: "Studien-Pseudonym", Id: "psn-42"}
+	cid := model.SignerId{IdType: "Fallnummer", Id: "4711"}
+
+	cases := []struct {
+		name            string
+		ids             []model.SignerId
+		idTypes         []string
+		expectedPrimary model.SignerId
+		expectedOthers  []model.SignerId
+		expectedErr     bool
+	}{
+		{
+			name:            "defaultFirst",
+			ids:             []model.SignerId{pid, psn},
+			expectedPrimary: pid,
+			expectedOthers:  []model.SignerId{psn},
+		},
+		{
+			name:            "byIdType",
+			ids:             []model.SignerId{pid, psn, cid},
+			idTypes:         []string{"Studien-Pseudonym"},
+			expectedPrimary: psn,
+			expectedOthers:  []model.SignerId{pid, cid},
+		},
+		{
+			name:            "byIdTypePriority",
+			ids:             []model.SignerId{pid, cid},
+			idTypes:         []string{"Studien-Pseudonym", "Fallnummer", "Patienten-ID"},
+			expectedPrimary: cid,
+			expectedOthers:  []model.SignerId{pid},
+		},
+		{
+			name:        "noMatch",
+			ids:         []model.SignerId{pid},
+			idTypes:     []string{"Studien-Pseudonym"},
+			expectedErr: true,
+		},
+		{
+			name:        "empty",
+			ids:         nil,
+			expectedErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			primary, others, err := selectSigner(c.ids, config.Signer{IdTypes: c.idTypes})
+
+			if c.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, c.expectedPrimary, primary)
+			assert.Equal(t, c.expectedOthers, others)
+		})
+	}
+}
+
+func TestSignerIdentifiers(t *testing.T) {
+	ids := []model.SignerId{
+		{IdType: "Studien-Pseudonym", Id: "psn-42"},
+		{IdType: "Fallnummer", Id: "4711"},
+	}
+	c := config.Signer{Systems: map[string]string{
+		"Studien-Pseudonym": "https://fhir.diz.uni-marburg.de/sid/study-psn",
+	}}
+
+	expected := []fhir.Identifier{
+		{System: Of("https://fhir.diz.uni-marburg.de/sid/study-psn"), Value: Of("psn-42")},
+		{System: Of("https://ths-greifswald.de/fhir/gics/identifiers/Fallnummer"), Value: Of("4711")},
+	}
+
+	assert.Equal(t, expected, signerIdentifiers(ids, c))
+}
@@ -0,0 +1,61 @@
+package mapper
+
+import (
+	"consent-to-fhir/pkg/config"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"strings"
+)
+
+// validateSource checks that an identifier system is configured, if source QuestionnaireResponses are kept
+func validateSource(c config.Mapper) error {
+	if c.KeepSource && (c.SourceSystem == nil || *c.SourceSystem == "") {
+		return errors.New("keep-source requires a source-system")
+	}
+	return nil
+}
+
+// mapSource gets the referenced QuestionnaireResponse from gICS and creates a bundle entry with a local identifier.
+// The returned reference points to the re-homed resource
+func (m *GicsMapper) mapSource(ctx context.Context, sourceRef string, patient *fhir.Reference) (*fhir.BundleEntry, *fhir.Reference, error) {
+	qr, err := m.Client.GetQuestionnaireResponse(ctx, sourceRef)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// local identifier from gICS resource id
+	id := sourceRef[strings.LastIndex(sourceRef, "/")+1:]
+	if qr.Id != nil {
+		id = *qr.Id
+	}
+	qr.Id = nil
+	qr.Meta = nil
+	qr.Identifier = &fhir.Identifier{
+		System: m.Config.SourceSystem,
+		Value:  &id,
+	}
+
+	// rewrite references to gICS resources
+	qr.Subject = patient
+	qr.Author = nil
+	qr.Source = nil
+	qr.Encounter = nil
+	qr.BasedOn = nil
+	qr.PartOf = nil
+
+	data, err := qr.MarshalJSON()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ref := fmt.Sprintf("QuestionnaireResponse?identifier=%s|%s", *m.Config.SourceSystem, id)
+	return &fhir.BundleEntry{
+		Resource: data,
+		Request: &fhir.BundleEntryRequest{
+			Method: fhir.HTTPVerbPUT,
+			Url:    ref,
+		},
+	}, &fhir.Reference{Reference: &ref}, nil
+}
@@ -0,0 +1,67 @@
+package mapper
+
+import (
+	"consent-to-fhir/pkg/config"
+	"context"
+	"fmt"
+	"github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestProcess_KeepSource(t *testing.T) {
+	m := createTestMapper()
+	m.Config.KeepSource = true
+	m.Config.SourceSystem = Of("https://fhir.diz.uni-marburg.de/sid/consent-source-id")
+	m.Client = &TestGicsClient{
+		respFilePath: "testdata/current-policies-response.json",
+	}
+
+	bundle, _ := m.Map(context.Background(), createTestNotification())
+	consent, _ := fhir.UnmarshalConsent(bundle.Entry[0].Resource)
+	qr, _ := fhir.UnmarshalQuestionnaireResponse(bundle.Entry[2].Resource)
+
+	expectedRef := fmt.Sprintf("QuestionnaireResponse?identifier=%s|%s", *m.Config.SourceSystem,
+		"c9da8f7a-c115-4872-80af-096a49cb0520")
+
+	assert.Equal(t, expectedRef, *consent.SourceReference.Reference)
+	assert.Equal(t, expectedRef, bundle.Entry[2].Request.Url)
+	assert.Equal(t, fhir.HTTPVerbPUT, bundle.Entry[2].Request.Method)
+	assert.Nil(t, qr.Id)
+	assert.Equal(t, "c9da8f7a-c115-4872-80af-096a49cb0520", *qr.Identifier.Value)
+	assert.Equal(t, consent.Patient, qr.Subject)
+}
+
+func TestProcess_DiscardSource(t *testing.T) {
+	m := createTestMapper()
+	m.Client = &TestGicsClient{
+		respFilePath: "testdata/current-policies-response.json",
+	}
+
+	bundle, _ := m.Map(context.Background(), createTestNotification())
+	consent, _ := fhir.UnmarshalConsent(bundle.Entry[0].Resource)
+
+	assert.Nil(t, consent.SourceReference)
+	assert.Len(t, bundle.Entry, 2)
+}
+
+func TestValidateSource(t *testing.T) {
+	cases := []struct {
+		name   string
+		config config.Mapper
+		valid  bool
+	}{
+		{name: "default", config: config.Mapper{}, valid: true},
+		{name: "keep source", config: config.Mapper{KeepSource: true, SourceSystem: Of("https://fhir.example.org/sid/source")}, valid: true},
+		{name: "keep source without system", config: config.Mapper{KeepSource: true}, valid: false},
+		{name: "keep source with empty system", config: config.Mapper{KeepSource: true, SourceSystem: Of("")}, valid: false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := validateSource(c.config)
+
+			assert.Equal(t, c.valid, err == nil)
+		})
+	}
+}
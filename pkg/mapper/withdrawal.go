@@ -0,0 +1,93 @@
+package mapper
+
+import (
+	"consent-to-fhir/pkg/config"
+	"context"
+	"fmt"
+	"github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"time"
+)
+
+const (
+	WithdrawalDelete   = "delete"
+	WithdrawalInactive = "inactive"
+)
+
+// validateWithdrawal checks the global and domain withdrawal strategies
+func validateWithdrawal(c config.Mapper) error {
+	if err := checkWithdrawal(c.Withdrawal); err != nil {
+		return err
+	}
+	for name, d := range c.Domains {
+		if err := checkWithdrawal(d.Withdrawal); err != nil {
+			return fmt.Errorf("%w of domain '%s'", err, name)
+		}
+	}
+	return nil
+}
+
+func checkWithdrawal(w string) error {
+	switch w {
+	case "", WithdrawalDelete, WithdrawalInactive:
+		return nil
+	default:
+		return fmt.Errorf("unknown withdrawal strategy '%s'", w)
+	}
+}
+
+// withdrawal returns the configured withdrawal strategy for the domain (default: delete)
+func (m *GicsMapper) withdrawal(domain string) string {
+	if w := m.Config.Domains[domain].Withdrawal; w != "" {
+		return w
+	}
+	if m.Config.Withdrawal != "" {
+		return m.Config.Withdrawal
+	}
+	return WithdrawalDelete
+}
+
+// createWithdrawalBundle keeps the withdrawn consent as an inactive resource with a top-level deny provision
+// starting at the withdrawal date
+func (m *GicsMapper) createWithdrawalBundle(ctx context.Context, info consentInfo) (*fhir.Bundle, error) {
+	withdrawn, err := withdrawalDate(info)
+	if err != nil {
+		return nil, err
+	}
+
+	c := newConsent(info.domain, fhir.ConsentStateInactive, withdrawn)
+	info.date = withdrawn
+
+	r, err := m.mapConsent(c, info)
+	if err != nil {
+		return nil, err
+	}
+
+	study, err := m.withdrawalDomain(ctx, info.domain)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ResearchStudy resource for domain '%s': %w", info.domain, err)
+	}
+
+	return m.createBundle(r, info, study)
+}
+
+// withdrawalDomain returns the domain's ResearchStudy. Withdrawn consents have no domain reference, so in gICS mode,
+// the reference of previously mapped consents is used. Without one, the domain is created from its name only
+func (m *GicsMapper) withdrawalDomain(ctx context.Context, domain string) (*fhir.ResearchStudy, error) {
+	ref, ok := m.domainRefs.Load(domain)
+	if m.isDirect(domain) || !ok {
+		return m.Direct.GetConsentDomain(domain), nil
+	}
+	return m.getConsentDomain(ctx, domain, Of(ref.(string)))
+}
+
+// withdrawalDate returns the date of the withdrawal from notification data: the re-evaluation date, if the consent
+// expired, or the date of the notification's consent (e.g. the revocation)
+func withdrawalDate(info consentInfo) (string, error) {
+	if info.evaluatedAt != nil {
+		return info.evaluatedAt.Format(time.RFC3339), nil
+	}
+	if _, err := time.Parse(time.RFC3339, info.date); err != nil {
+		return "", fmt.Errorf("missing or invalid withdrawal date '%s': %w", info.date, err)
+	}
+	return info.date, nil
+}
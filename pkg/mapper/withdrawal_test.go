@@ -0,0 +1,144 @@
+package mapper
+
+import (
+	"consent-to-fhir/pkg/config"
+	"consent-to-fhir/pkg/model"
+	"context"
+	"fmt"
+	"github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestWithdrawal(t *testing.T) {
+	cases := []struct {
+		name     string
+		config   config.Mapper
+		expected string
+	}{
+		{
+			name:     "default",
+			config:   config.Mapper{},
+			expected: WithdrawalDelete,
+		},
+		{
+			name:     "global",
+			config:   config.Mapper{Withdrawal: WithdrawalInactive},
+			expected: WithdrawalInactive,
+		},
+		{
+			name: "domain",
+			config: config.Mapper{
+				Withdrawal: WithdrawalInactive,
+				Domains:    map[string]config.Domain{"MII": {Withdrawal: WithdrawalDelete}},
+			},
+			expected: WithdrawalDelete,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			m := &GicsMapper{Config: c.config}
+
+			assert.Equal(t, c.expected, m.withdrawal("MII"))
+		})
+	}
+}
+
+func TestProcess_MissingConsent_Inactive(t *testing.T) {
+	m := createTestMapper()
+	m.Config.Withdrawal = WithdrawalInactive
+	m.Client = &TestGicsClient{
+		respFilePath: "testdata/empty-policies-response.json",
+	}
+	consentDate, _ := parseConsentDate("2023-05-02 01:57:27")
+	expiredAt := time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name     string
+		run      func(n model.Notification) (*fhir.Bundle, error)
+		expected string
+	}{
+		{
+			name: "consentDate",
+			run: func(n model.Notification) (*fhir.Bundle, error) {
+				return m.Map(context.Background(), n)
+			},
+			expected: consentDate,
+		},
+		{
+			name: "reevaluated",
+			run: func(n model.Notification) (*fhir.Bundle, error) {
+				return m.Reevaluate(context.Background(), n, expiredAt)
+			},
+			expected: "2024-05-02T00:00:00Z",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			bundle, err := c.run(createTestNotification())
+			assert.NoError(t, err)
+			actual, _ := fhir.UnmarshalConsent(bundle.Entry[0].Resource)
+
+			assert.Equal(t, fhir.BundleEntryRequest{
+				Method: fhir.HTTPVerbPUT,
+				Url:    fmt.Sprintf("Consent?identifier=%s|%s", *m.Config.ConsentSystem, hash("MII", "42")),
+			}, *bundle.Entry[0].Request)
+			assert.Equal(t, fhir.ConsentStateInactive, actual.Status)
+			assert.Equal(t, fhir.ConsentProvisionTypeDeny, *actual.Provision.Type)
+			assert.Empty(t, actual.Provision.Provision)
+			assert.Equal(t, c.expected, *actual.DateTime)
+			assert.Equal(t, c.expected, *actual.Provision.Period.Start)
+			assert.Equal(t, []string{MiiProfile}, actual.Meta.Profile)
+		})
+	}
+}
+
+func TestProcess_MissingConsent_Domain(t *testing.T) {
+	m := createTestMapper()
+	m.Config.Withdrawal = WithdrawalInactive
+	withdrawn := &TestGicsClient{respFilePath: "testdata/empty-policies-response.json"}
+
+	// no domain reference known
+	m.Client = withdrawn
+	bundle, err := m.Map(context.Background(), createTestNotification())
+	assert.NoError(t, err)
+	study, _ := fhir.UnmarshalResearchStudy(bundle.Entry[1].Resource)
+	assert.Equal(t, "MII", *study.Title)
+
+	// domain reference of a mapped consent
+	m.Client = &TestGicsClient{respFilePath: "testdata/current-policies-response.json"}
+	_, err = m.Map(context.Background(), createTestNotification())
+	assert.NoError(t, err)
+
+	m.Client = withdrawn
+	bundle, err = m.Map(context.Background(), createTestNotification())
+	assert.NoError(t, err)
+	study, _ = fhir.UnmarshalResearchStudy(bundle.Entry[1].Resource)
+	assert.Equal(t, "Test", *study.Title)
+	assert.Equal(t, "Test domain", *study.Description)
+}
+
+func TestValidateWithdrawal(t *testing.T) {
+	cases := []struct {
+		name    string
+		config  config.Mapper
+		wantErr bool
+	}{
+		{"default", config.Mapper{}, false},
+		{"valid", config.Mapper{Withdrawal: WithdrawalInactive,
+			Domains: map[string]config.Domain{"MII": {Withdrawal: WithdrawalDelete}}}, false},
+		{"invalid", config.Mapper{Withdrawal: "inactiv"}, true},
+		{"invalidDomain", config.Mapper{Domains: map[string]config.Domain{"MII": {Withdrawal: "keep"}}}, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := validateWithdrawal(c.config)
+
+			assert.Equal(t, c.wantErr, err != nil)
+		})
+	}
+}
@@ -11,7 +11,18 @@ type PolicyStateKey struct {
 	Version    *string `bson:"version" json:"version"`
 }
 
+const (
+	AddConsent            = "GICS.AddConsent"
+	AddConsentWithScans   = "GICS.AddConsentWithScans"
+	RefuseConsent         = "GICS.RefuseConsent"
+	UpdateConsentInUse    = "GICS.UpdateConsentInUse"
+	Revoke                = "GICS.Revoke"
+	AddSignerIdToSignerId = "GICS.AddSignerIdToSignerId"
+)
+
 type Notification struct {
+	Type                 string        `bson:"type" json:"type"`
+	ClientId             string        `bson:"clientId" json:"clientId"`
 	Context              *Context      `bson:"context" json:"context"`
 	ConsentKey           *ConsentKey   `bson:"consentKey" json:"consentKey"`
 	PreviousPolicyStates []PolicyState `bson:"previousPolicyStates" json:"previousPolicyStates"`
@@ -19,12 +30,14 @@ type Notification struct {
 }
 
 type Context struct {
-	Qc struct {
-		QcPassed  bool   `bson:"qcPassed" json:"qcPassed"`
-		Type      string `bson:"type json:type"`
-		Inspector string `bson:"inspector json:inspector"`
-		Comment   string `bson:"comment" json:"comment"`
-	} `bson:"qc" json:"qc"`
+	Qc *Qc `bson:"qc" json:"qc"`
+}
+
+type Qc struct {
+	QcPassed  bool   `bson:"qcPassed" json:"qcPassed"`
+	Type      string `bson:"type" json:"type"`
+	Inspector string `bson:"inspector" json:"inspector"`
+	Comment   string `bson:"comment" json:"comment"`
 }
 
 type ConsentKey struct {
@@ -0,0 +1,208 @@
+package scheduler
+
+import (
+	"bufio"
+	"consent-to-fhir/pkg/model"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+// compactThreshold is the minimum number of obsolete records, before the queue file is compacted
+const compactThreshold = 1000
+
+// Entry is a scheduled re-evaluation of a notification's consent. The notification holds only the data
+// required for re-evaluation (see Reduce)
+type Entry struct {
+	Key          string             `json:"key"`
+	Due          time.Time          `json:"due"`
+	MessageKey   []byte             `json:"messageKey"`
+	Notification model.Notification `json:"notification"`
+	Attempts     int                `json:"attempts,omitempty"`
+}
+
+// record is a change of the queue, appended to the queue file
+type record struct {
+	Remove string `json:"remove,omitempty"`
+	Entry  *Entry `json:"entry,omitempty"`
+}
+
+// FileQueue keeps one entry per key. Changes are appended to a JSON lines file, if configured, which is
+// replayed on startup and compacted once it mostly contains obsolete records
+type FileQueue struct {
+	mu      sync.Mutex
+	path    string
+	entries map[string]Entry
+	file    *os.File
+	records int
+}
+
+func NewFileQueue(path string) (*FileQueue, error) {
+	q := &FileQueue{path: path, entries: make(map[string]Entry)}
+	if path == "" {
+		return q, nil
+	}
+
+	if err := q.replay(); err != nil {
+		return nil, fmt.Errorf("failed to read expiry queue file '%s': %w", path, err)
+	}
+	if err := q.compact(); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// Reduce returns the notification data required to re-evaluate its consent: type, quality control context,
+// consent key and current policy states
+func Reduce(n model.Notification) model.Notification {
+	return model.Notification{
+		Type:                n.Type,
+		Context:             n.Context,
+		ConsentKey:          n.ConsentKey,
+		CurrentPolicyStates: n.CurrentPolicyStates,
+	}
+}
+
+// Put adds the entry or replaces the existing entry with the same key. Unchanged entries are not written
+func (q *FileQueue) Put(e Entry) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if existing, ok := q.entries[e.Key]; ok && existing.Due.Equal(e.Due) {
+		existing.Due = e.Due
+		if reflect.DeepEqual(existing, e) {
+			return nil
+		}
+	}
+
+	q.entries[e.Key] = e
+	return q.append(record{Entry: &e})
+}
+
+// Remove removes the entry with the given key, if it exists
+func (q *FileQueue) Remove(key string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.entries[key]; !ok {
+		return nil
+	}
+	delete(q.entries, key)
+	return q.append(record{Remove: key})
+}
+
+// Due returns all entries due at the given date, ordered by due date
+func (q *FileQueue) Due(now time.Time) []Entry {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var due []Entry
+	for _, e := range q.entries {
+		if !e.Due.After(now) {
+			due = append(due, e)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].Due.Before(due[j].Due) })
+	return due
+}
+
+// Len returns the number of scheduled entries
+func (q *FileQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.entries)
+}
+
+// replay reads all records of the queue file
+func (q *FileQueue) replay() error {
+	f, err := os.Open(q.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var r record
+		if err = json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return err
+		}
+		if r.Entry != nil {
+			q.entries[r.Entry.Key] = *r.Entry
+		} else {
+			delete(q.entries, r.Remove)
+		}
+	}
+	return scanner.Err()
+}
+
+// append writes the record to the queue file and compacts it, if it mostly contains obsolete records
+func (q *FileQueue) append(r record) error {
+	if q.file == nil {
+		return nil
+	}
+
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if _, err = q.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+
+	q.records++
+	if q.records-len(q.entries) > max(compactThreshold, len(q.entries)) {
+		return q.compact()
+	}
+	return nil
+}
+
+// compact writes the current entries to a temporary file, which replaces the queue file, and reopens it
+// for appending
+func (q *FileQueue) compact() error {
+	if q.file != nil {
+		_ = q.file.Close()
+		q.file = nil
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(q.path), filepath.Base(q.path)+".*")
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(tmp)
+	for _, e := range q.entries {
+		line, err := json.Marshal(record{Entry: &e})
+		if err == nil {
+			_, err = w.Write(append(line, '\n'))
+		}
+		if err != nil {
+			_ = tmp.Close()
+			_ = os.Remove(tmp.Name())
+			return err
+		}
+	}
+	if err = w.Flush(); err == nil {
+		err = tmp.Close()
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err = os.Rename(tmp.Name(), q.path); err != nil {
+		return err
+	}
+
+	q.file, err = os.OpenFile(q.path, os.O_WRONLY|os.O_APPEND, 0o600)
+	q.records = len(q.entries)
+	return err
+}
@@ -0,0 +1,111 @@
+package scheduler
+
+import (
+	"consent-to-fhir/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileQueue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.jsonl")
+	now := time.Date(2028, 12, 11, 0, 0, 0, 0, time.UTC)
+
+	q, err := NewFileQueue(path)
+	assert.NoError(t, err)
+
+	assert.NoError(t, q.Put(Entry{Key: "a", Due: now.AddDate(0, 0, 1)}))
+	assert.NoError(t, q.Put(Entry{Key: "b", Due: now}))
+	assert.NoError(t, q.Put(Entry{Key: "c", Due: now.AddDate(0, 0, -1)}))
+	// replace
+	assert.NoError(t, q.Put(Entry{Key: "a", Due: now.AddDate(1, 0, 0)}))
+
+	// reload
+	q, err = NewFileQueue(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, q.Len())
+
+	due := q.Due(now)
+	assert.Len(t, due, 2)
+	assert.Equal(t, "c", due[0].Key)
+	assert.Equal(t, "b", due[1].Key)
+
+	assert.NoError(t, q.Remove("b"))
+	assert.NoError(t, q.Remove("unknown"))
+
+	q, _ = NewFileQueue(path)
+	assert.Equal(t, 2, q.Len())
+	assert.Len(t, q.Due(now), 1)
+}
+
+func TestFileQueue_Append(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.jsonl")
+	now := time.Date(2028, 12, 11, 0, 0, 0, 0, time.UTC)
+
+	q, _ := NewFileQueue(path)
+	assert.NoError(t, q.Put(Entry{Key: "a", Due: now}))
+	assert.NoError(t, q.Put(Entry{Key: "b", Due: now}))
+	size := fileSize(t, path)
+
+	// unchanged entries are not written
+	assert.NoError(t, q.Put(Entry{Key: "a", Due: now}))
+	assert.Equal(t, size, fileSize(t, path))
+
+	// changes are appended
+	assert.NoError(t, q.Put(Entry{Key: "a", Due: now.AddDate(0, 0, 1)}))
+	assert.NoError(t, q.Remove("b"))
+	assert.Greater(t, fileSize(t, path), size)
+
+	// compacted on startup
+	q, _ = NewFileQueue(path)
+	assert.Equal(t, 1, q.Len())
+	assert.Less(t, fileSize(t, path), size)
+}
+
+func TestFileQueue_Compact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.jsonl")
+	now := time.Date(2028, 12, 11, 0, 0, 0, 0, time.UTC)
+
+	q, _ := NewFileQueue(path)
+	for i := range compactThreshold + 2 {
+		assert.NoError(t, q.Put(Entry{Key: "a", Due: now.Add(time.Duration(i) * time.Hour)}))
+	}
+
+	assert.Equal(t, 1, q.records)
+	q, _ = NewFileQueue(path)
+	assert.Equal(t, compactThreshold+1, int(q.Due(now.AddDate(1, 0, 0))[0].Due.Sub(now).Hours()))
+}
+
+func TestReduce(t *testing.T) {
+	n := model.Notification{
+		Type:                 model.AddConsent,
+		ClientId:             "client",
+		Context:              &model.Context{Qc: &model.Qc{QcPassed: true}},
+		ConsentKey:           &model.ConsentKey{SignerIds: []model.SignerId{{IdType: "Patienten-ID", Id: "42"}}},
+		PreviousPolicyStates: []model.PolicyState{{Value: true}},
+		CurrentPolicyStates:  []model.PolicyState{{Value: false}},
+	}
+
+	assert.Equal(t, model.Notification{
+		Type:                model.AddConsent,
+		Context:             n.Context,
+		ConsentKey:          n.ConsentKey,
+		CurrentPolicyStates: n.CurrentPolicyStates,
+	}, Reduce(n))
+}
+
+func fileSize(t *testing.T, path string) int64 {
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	return info.Size()
+}
+
+func TestFileQueue_InMemory(t *testing.T) {
+	q, err := NewFileQueue("")
+
+	assert.NoError(t, err)
+	assert.NoError(t, q.Put(Entry{Key: "a", Due: time.Now()}))
+	assert.Equal(t, 1, q.Len())
+}
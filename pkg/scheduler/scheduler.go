@@ -0,0 +1,175 @@
+package scheduler
+
+import (
+	"consent-to-fhir/pkg/mapper"
+	"consent-to-fhir/pkg/model"
+	"context"
+	"github.com/samply/golang-fhir-models/fhir-models/fhir"
+	log "github.com/sirupsen/logrus"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Evaluator maps the notification's consent state at the given date
+type Evaluator func(ctx context.Context, n model.Notification, at time.Time) (*fhir.Bundle, error)
+
+// Publisher sends the re-evaluated bundle of the entry
+type Publisher func(e Entry, bundle *fhir.Bundle) error
+
+// DeadLetter sends an entry, which failed too often, to the dead-letter topic
+type DeadLetter func(e Entry, err error) error
+
+// defaultMaxAttempts is the number of attempts to re-evaluate an entry, if not configured
+const defaultMaxAttempts = 10
+
+// Scheduler re-evaluates consents, when their provision periods end
+type Scheduler struct {
+	Queue       *FileQueue
+	Evaluate    Evaluator
+	Publish     Publisher
+	DeadLetter  DeadLetter
+	Interval    time.Duration
+	MaxAttempts int
+}
+
+func NewScheduler(queue *FileQueue, evaluate Evaluator, publish Publisher, interval time.Duration) *Scheduler {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	return &Scheduler{Queue: queue, Evaluate: evaluate, Publish: publish, Interval: interval}
+}
+
+// Schedule returns a function, which queues the next provision period end of the mapped bundle. It must be called
+// once the bundle has been delivered. Existing entries of the notification's consent are replaced or removed, if
+// there is no upcoming end date. Returns nil, if the notification has no consent key
+func (s *Scheduler) Schedule(msgKey []byte, n model.Notification, bundle *fhir.Bundle, now time.Time) func() error {
+	key := notificationKey(n)
+	if key == "" {
+		return nil
+	}
+
+	// determined before delivery, which may convert the bundle
+	due, ok := mapper.NextExpiry(bundle, now)
+	if !ok {
+		return func() error { return s.Queue.Remove(key) }
+	}
+
+	e := Entry{Key: key, Due: due, MessageKey: msgKey, Notification: Reduce(n)}
+	return func() error {
+		log.WithFields(log.Fields{"key": key, "due": due}).Debug("Consent expiry scheduled")
+		return s.Queue.Put(e)
+	}
+}
+
+// Run processes due entries periodically until ctx is done
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	for {
+		s.ProcessDue(ctx, time.Now())
+
+		select {
+		case <-ctx.Done():
+			log.Info("Expiry scheduler stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ProcessDue re-evaluates and publishes all entries due at the given date. Failed entries are kept and retried
+// up to MaxAttempts times, then they are sent to the dead-letter topic and removed
+func (s *Scheduler) ProcessDue(ctx context.Context, now time.Time) {
+	for _, e := range s.Queue.Due(now) {
+		if ctx.Err() != nil {
+			return
+		}
+
+		logger := log.WithFields(log.Fields{"key": e.Key, "due": e.Due})
+
+		bundle, err := s.Evaluate(ctx, e.Notification, e.Due)
+		if err != nil {
+			if ctx.Err() == nil {
+				logger.WithError(err).Error("Failed to re-evaluate expired consent")
+				s.failed(e, err)
+			}
+			continue
+		}
+		if bundle == nil {
+			_ = s.Queue.Remove(e.Key)
+			continue
+		}
+
+		// schedule next end date before publishing, which may convert the bundle
+		next, hasNext := mapper.NextExpiry(bundle, e.Due)
+
+		if err = s.Publish(e, bundle); err != nil {
+			logger.WithError(err).Error("Failed to publish re-evaluated consent")
+			s.failed(e, err)
+			continue
+		}
+		logger.Info("Expired consent re-evaluated and published")
+
+		if hasNext {
+			e.Due = next
+			e.Attempts = 0
+			err = s.Queue.Put(e)
+		} else {
+			err = s.Queue.Remove(e.Key)
+		}
+		if err != nil {
+			logger.WithError(err).Error("Failed to update expiry queue")
+		}
+	}
+}
+
+// failed counts the failed attempt of the entry. Once MaxAttempts is reached, the entry is sent to the
+// dead-letter topic, if configured, and removed
+func (s *Scheduler) failed(e Entry, cause error) {
+	logger := log.WithFields(log.Fields{"key": e.Key, "due": e.Due})
+
+	e.Attempts++
+	if e.Attempts < s.maxAttempts() {
+		if err := s.Queue.Put(e); err != nil {
+			logger.WithError(err).Error("Failed to update expiry queue")
+		}
+		return
+	}
+
+	if s.DeadLetter != nil {
+		if err := s.DeadLetter(e, cause); err != nil {
+			logger.WithError(err).Error("Failed to send expired consent to dead-letter topic. Retrying")
+			return
+		}
+	}
+	logger.WithField("attempts", e.Attempts).Error("Expired consent failed too often. Removing")
+	if err := s.Queue.Remove(e.Key); err != nil {
+		logger.WithError(err).Error("Failed to update expiry queue")
+	}
+}
+
+// maxAttempts returns the number of attempts to re-evaluate an entry
+func (s *Scheduler) maxAttempts() int {
+	if s.MaxAttempts > 0 {
+		return s.MaxAttempts
+	}
+	return defaultMaxAttempts
+}
+
+// notificationKey identifies the consent by domain and signer ids
+func notificationKey(n model.Notification) string {
+	domain := mapper.DomainOf(n)
+	if domain == "" {
+		return ""
+	}
+
+	ids := make([]string, 0, len(n.ConsentKey.SignerIds))
+	for _, id := range n.ConsentKey.SignerIds {
+		ids = append(ids, id.IdType+"="+id.Id)
+	}
+	sort.Strings(ids)
+
+	return domain + "|" + strings.Join(ids, ",")
+}
@@ -0,0 +1,175 @@
+package scheduler
+
+import (
+	"consent-to-fhir/pkg/model"
+	"context"
+	"errors"
+	"github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func createTestNotification() model.Notification {
+	domain := "MII"
+	return model.Notification{
+		ConsentKey: &model.ConsentKey{
+			ConsentTemplateKey: &model.ConsentTemplateKey{DomainName: &domain},
+			SignerIds:          []model.SignerId{{IdType: "Patienten-ID", Id: "42"}},
+		},
+	}
+}
+
+func createTestBundle(ends ...string) *fhir.Bundle {
+	var provisions []fhir.ConsentProvision
+	for _, end := range ends {
+		provisions = append(provisions, fhir.ConsentProvision{Period: &fhir.Period{End: &end}})
+	}
+	data, _ := fhir.Consent{Provision: &fhir.ConsentProvision{Provision: provisions}}.MarshalJSON()
+
+	return &fhir.Bundle{Entry: []fhir.BundleEntry{{
+		Resource: data,
+		Request:  &fhir.BundleEntryRequest{Method: fhir.HTTPVerbPUT, Url: "Consent?identifier=sys|1"},
+	}}}
+}
+
+func TestSchedule(t *testing.T) {
+	q, _ := NewFileQueue("")
+	s := NewScheduler(q, nil, nil, 0)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	n := createTestNotification()
+
+	schedule := s.Schedule([]byte("msg"), n, createTestBundle("2053-12-11T00:00:00+01:00", "2028-12-11T00:00:00+01:00"), now)
+
+	// not delivered yet
+	assert.Equal(t, 0, q.Len())
+	assert.NoError(t, schedule())
+
+	due := q.Due(now.AddDate(100, 0, 0))
+	assert.Len(t, due, 1)
+	assert.Equal(t, "MII|Patienten-ID=42", due[0].Key)
+	assert.Equal(t, []byte("msg"), due[0].MessageKey)
+	assert.True(t, time.Date(2028, 12, 10, 23, 0, 0, 0, time.UTC).Equal(due[0].Due))
+
+	// no upcoming end date
+	assert.NoError(t, s.Schedule([]byte("msg"), n, createTestBundle("2023-12-11T00:00:00+01:00"), now)())
+	assert.Equal(t, 0, q.Len())
+
+	// no consent key
+	assert.Nil(t, s.Schedule([]byte("msg"), model.Notification{}, createTestBundle(), now))
+}
+
+func TestProcessDue(t *testing.T) {
+	q, _ := NewFileQueue("")
+	first := time.Date(2028, 12, 11, 0, 0, 0, 0, time.UTC)
+	_ = q.Put(Entry{Key: "a", Due: first, MessageKey: []byte("msg"), Notification: createTestNotification()})
+
+	var evaluated []time.Time
+	var published [][]byte
+	s := NewScheduler(q,
+		func(_ context.Context, n model.Notification, at time.Time) (*fhir.Bundle, error) {
+			evaluated = append(evaluated, at)
+			return createTestBundle("2028-12-11T00:00:00Z", "2053-12-11T00:00:00Z"), nil
+		},
+		func(e Entry, bundle *fhir.Bundle) error {
+			published = append(published, e.MessageKey)
+			return nil
+		}, 0)
+
+	// not due yet
+	s.ProcessDue(context.Background(), first.Add(-time.Second))
+	assert.Empty(t, evaluated)
+
+	s.ProcessDue(context.Background(), first)
+	assert.Equal(t, []time.Time{first}, evaluated)
+	assert.Equal(t, [][]byte{[]byte("msg")}, published)
+
+	// next end date
+	due := q.Due(first.AddDate(100, 0, 0))
+	assert.Len(t, due, 1)
+	assert.True(t, time.Date(2053, 12, 11, 0, 0, 0, 0, time.UTC).Equal(due[0].Due))
+}
+
+func TestProcessDue_Failed(t *testing.T) {
+	q, _ := NewFileQueue("")
+	due := time.Date(2028, 12, 11, 0, 0, 0, 0, time.UTC)
+	_ = q.Put(Entry{Key: "a", Due: due, Notification: createTestNotification()})
+
+	s := NewScheduler(q,
+		func(_ context.Context, n model.Notification, at time.Time) (*fhir.Bundle, error) {
+			return nil, errors.New("gICS not available")
+		}, nil, 0)
+
+	s.ProcessDue(context.Background(), due)
+
+	// kept for retry
+	assert.Len(t, q.Due(due), 1)
+	assert.Equal(t, 1, q.Due(due)[0].Attempts)
+}
+
+func TestProcessDue_DeadLetter(t *testing.T) {
+	due := time.Date(2028, 12, 11, 0, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name           string
+		deadLetterErr  error
+		expectedQueued int
+	}{
+		{"sent", nil, 0},
+		// retried
+		{"failed", errors.New("broker not available"), 1},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			q, _ := NewFileQueue("")
+			_ = q.Put(Entry{Key: "a", Due: due, Notification: createTestNotification(), Attempts: 1})
+
+			var deadLetters []Entry
+			s := NewScheduler(q,
+				func(_ context.Context, n model.Notification, at time.Time) (*fhir.Bundle, error) {
+					return nil, errors.New("gICS not available")
+				}, nil, 0)
+			s.MaxAttempts = 2
+			s.DeadLetter = func(e Entry, err error) error {
+				deadLetters = append(deadLetters, e)
+				return c.deadLetterErr
+			}
+
+			s.ProcessDue(context.Background(), due)
+
+			assert.Len(t, deadLetters, 1)
+			assert.Equal(t, 2, deadLetters[0].Attempts)
+			assert.Equal(t, c.expectedQueued, q.Len())
+			if c.expectedQueued > 0 {
+				assert.Equal(t, 1, q.Due(due)[0].Attempts)
+			}
+		})
+	}
+}
+
+func TestProcessDue_Canceled(t *testing.T) {
+	q, _ := NewFileQueue("")
+	due := time.Date(2028, 12, 11, 0, 0, 0, 0, time.UTC)
+	_ = q.Put(Entry{Key: "a", Due: due, Notification: createTestNotification()})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := NewScheduler(q,
+		func(ctx context.Context, n model.Notification, at time.Time) (*fhir.Bundle, error) {
+			cancel()
+			return nil, ctx.Err()
+		}, nil, 0)
+
+	s.ProcessDue(ctx, due)
+
+	// not counted as failed attempt
+	assert.Equal(t, 0, q.Due(due)[0].Attempts)
+}
+
+func TestNotificationKey(t *testing.T) {
+	n := createTestNotification()
+	n.ConsentKey.SignerIds = []model.SignerId{{IdType: "b", Id: "2"}, {IdType: "a", Id: "1"}}
+
+	assert.Equal(t, "MII|a=1,b=2", notificationKey(n))
+	assert.Equal(t, "", notificationKey(model.Notification{}))
+}